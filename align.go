@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+const (
+	// alignRate is the sample rate references and input are compared at;
+	// plenty for timing, and it keeps the correlation small.
+	alignRate = 4000
+	// alignRefSeconds is how much of each reference is matched.
+	alignRefSeconds = 15
+	// alignMinScore is the normalised correlation below which a match is
+	// treated as not found.
+	alignMinScore = 0.2
+)
+
+// alignRef is a "N=path" --align value: a clean copy of track N (or a
+// snippet starting where track N starts) to locate in the recording.
+type alignRef struct {
+	Track int
+	Path  string
+}
+
+// alignList is a repeatable --align flag. A bare path refers to track 1.
+type alignList []alignRef
+
+func (l *alignList) String() string {
+	refs := make([]string, len(*l))
+	for i, r := range *l {
+		refs[i] = fmt.Sprintf("%d=%s", r.Track, r.Path)
+	}
+	return strings.Join(refs, ", ")
+}
+
+func (l *alignList) Set(v string) error {
+	ref := alignRef{Track: 1, Path: v}
+	if n, path, ok := strings.Cut(v, "="); ok {
+		// A path that merely contains "=" is taken as a whole.
+		if track, err := strconv.Atoi(n); err == nil {
+			if track < 1 {
+				return fmt.Errorf("align track must be 1 or more")
+			}
+			ref = alignRef{Track: track, Path: path}
+		}
+	}
+	if ref.Path == "" {
+		return fmt.Errorf("align reference must be a path or N=path")
+	}
+	*l = append(*l, ref)
+	return nil
+}
+
+// AlignPoint is where one reference was found relative to its track's
+// tracklist start.
+type AlignPoint struct {
+	Track  int
+	Start  float64
+	Offset float64
+	Score  float64
+}
+
+// alignTracks locates every reference in the input within window seconds of
+// its track's start and shifts all start times to match. One reference gives
+// a constant offset; more fit a linear drift, for recordings whose clock ran
+// fast or slow relative to the tracklist. A reference's track is numbered as
+// its output will be (see numberedTracks), although tracks still holds the
+// tracklist's segments and duplicate starts, so every start is shifted.
+func alignTracks(tracks []Track, numbered []int, input, audioMap string, refs alignList, window float64) ([]AlignPoint, error) {
+	var points []AlignPoint
+	for _, ref := range refs {
+		if ref.Track > len(numbered) {
+			return nil, fmt.Errorf("align reference %s: tracklist has %d tracks", ref.Path, len(numbered))
+		}
+		start := tracks[numbered[ref.Track-1]].StartTime
+		offset, score, err := locateReference(input, audioMap, ref.Path, start, window)
+		if err != nil {
+			return nil, fmt.Errorf("align reference %s: %v", ref.Path, err)
+		}
+		if score < alignMinScore {
+			return nil, fmt.Errorf("align reference %s: no match within %s of %s (score %.2f); check the track number or widen --align-window",
+				ref.Path, formatTimestamp(window), formatTimestamp(start), score)
+		}
+		points = append(points, AlignPoint{Track: ref.Track, Start: start, Offset: offset, Score: score})
+	}
+
+	a, b := fitDrift(points)
+	for i := range tracks {
+		tracks[i].StartTime = math.Max(0, tracks[i].StartTime+a+b*tracks[i].StartTime)
+	}
+	return points, nil
+}
+
+// numberedTracks is the index in tracks of each track the split will
+// number, in order: segments count only as bonus tracks, and, unless
+// duplicates is DuplicatesError, a track starting with the one before is
+// merged into it.
+func numberedTracks(tracks []Track, segments, duplicates string) []int {
+	var numbered []int
+	for i, t := range tracks {
+		if t.Segment != "" && segments != SegmentsBonus {
+			continue
+		}
+		if n := len(numbered); n > 0 && duplicates != DuplicatesError {
+			if prev := tracks[numbered[n-1]]; t.Segment == "" && prev.Segment == "" && t.StartTime == prev.StartTime {
+				continue
+			}
+		}
+		numbered = append(numbered, i)
+	}
+	return numbered
+}
+
+// fitDrift fits offset = a + b*start by least squares. With a single point,
+// or points at the same start, it is a constant offset.
+func fitDrift(points []AlignPoint) (a, b float64) {
+	n := float64(len(points))
+	var sx, sy, sxx, sxy float64
+	for _, p := range points {
+		sx += p.Start
+		sy += p.Offset
+		sxx += p.Start * p.Start
+		sxy += p.Start * p.Offset
+	}
+	if d := n*sxx - sx*sx; len(points) > 1 && d > 1e-9 {
+		b = (n*sxy - sx*sy) / d
+	}
+	return (sy - b*sx) / n, b
+}
+
+// locateReference returns how far from start the reference actually begins
+// in the input, and the normalised correlation of the match.
+func locateReference(input, audioMap, ref string, start, window float64) (float64, float64, error) {
+	refSamples, err := decodeMono(ref, 0, alignRefSeconds, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	from := math.Max(0, start-window)
+	span := start + window + alignRefSeconds - from
+	haystack, err := decodeMono(input, from, span, append(inputArgs(input), "-map", audioMap))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(refSamples) == 0 || len(haystack) < len(refSamples) {
+		return 0, 0, fmt.Errorf("not enough audio to compare")
+	}
+
+	lag, score := bestLag(haystack, refSamples)
+	return from + float64(lag)/alignRate - start, score, nil
+}
+
+// decodeMono decodes dur seconds from offset as mono alignRate samples.
+// inArgs replaces the plain "-i path" when set.
+func decodeMono(path string, offset, dur float64, inArgs []string) ([]float64, error) {
+	if inArgs == nil {
+		inArgs = []string{"-i", path}
+	}
+	args := []string{"-v", "error", "-ss", fmt.Sprintf("%.3f", offset)}
+	args = append(args, inArgs...)
+	args = append(args, "-t", fmt.Sprintf("%.3f", dur),
+		"-vn", "-ac", "1", "-ar", strconv.Itoa(alignRate), "-f", "s16le", "-")
+
+	var stderr bytes.Buffer
+	var out bytes.Buffer
+	err := runFFmpeg(context.Background(), &Command{Args: args, Stdout: &out, Stderr: &stderr})
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\nOutput: %s", err, stderr.String())
+	}
+
+	pcm := out.Bytes()
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+	}
+	return samples, nil
+}
+
+// bestLag finds where needle best matches inside haystack by FFT
+// cross-correlation, returning the sample offset and the normalised
+// correlation there (1 is a perfect match).
+func bestLag(haystack, needle []float64) (int, float64) {
+	n := 1
+	for n < len(haystack)+len(needle) {
+		n <<= 1
+	}
+	h := make([]complex128, n)
+	for i, v := range haystack {
+		h[i] = complex(v, 0)
+	}
+	k := make([]complex128, n)
+	for i, v := range needle {
+		k[i] = complex(v, 0)
+	}
+	fft(h, false)
+	fft(k, false)
+	for i := range h {
+		h[i] *= cmplx.Conj(k[i])
+	}
+	fft(h, true)
+
+	var needleEnergy float64
+	for _, v := range needle {
+		needleEnergy += v * v
+	}
+	// Running energy of the haystack segment under the needle.
+	prefix := make([]float64, len(haystack)+1)
+	for i, v := range haystack {
+		prefix[i+1] = prefix[i] + v*v
+	}
+
+	best, bestScore := 0, -1.0
+	for lag := 0; lag+len(needle) <= len(haystack); lag++ {
+		energy := prefix[lag+len(needle)] - prefix[lag]
+		if energy == 0 || needleEnergy == 0 {
+			continue
+		}
+		score := real(h[lag]) / float64(n) / math.Sqrt(energy*needleEnergy)
+		if score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	return best, bestScore
+}
+
+// fft is an in-place iterative radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := x[start+k]
+				v := x[start+k+size/2] * w
+				x[start+k] = u + v
+				x[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFitDrift(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []AlignPoint
+		a, b   float64
+	}{
+		{name: "one reference", points: []AlignPoint{{Start: 600, Offset: 4}}, a: 4},
+		{name: "constant offset", points: []AlignPoint{{Start: 0, Offset: -2}, {Start: 3600, Offset: -2}}, a: -2},
+		{name: "drift", points: []AlignPoint{{Start: 0, Offset: 1}, {Start: 1000, Offset: 3}, {Start: 2000, Offset: 5}}, a: 1, b: 0.002},
+		{name: "same start", points: []AlignPoint{{Start: 600, Offset: 2}, {Start: 600, Offset: 4}}, a: 3},
+	}
+	for _, tt := range tests {
+		a, b := fitDrift(tt.points)
+		if math.Abs(a-tt.a) > 1e-9 || math.Abs(b-tt.b) > 1e-9 {
+			t.Errorf("%s: fitDrift() = %v, %v, want %v, %v", tt.name, a, b, tt.a, tt.b)
+		}
+	}
+}
+
+func TestNumberedTracks(t *testing.T) {
+	tracks := []Track{
+		{StartTime: 0},
+		{StartTime: 100, Segment: "MC"},
+		{StartTime: 120},
+		{StartTime: 120},
+		{StartTime: 200},
+	}
+	tests := []struct {
+		segments, duplicates string
+		want                 []int
+	}{
+		{segments: SegmentsExclude, duplicates: DuplicatesMerge, want: []int{0, 2, 4}},
+		{segments: SegmentsInclude, duplicates: "", want: []int{0, 2, 4}},
+		{segments: SegmentsBonus, duplicates: DuplicatesMerge, want: []int{0, 1, 2, 4}},
+		{segments: SegmentsExclude, duplicates: DuplicatesError, want: []int{0, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if got := numberedTracks(tracks, tt.segments, tt.duplicates); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("numberedTracks(%q, %q) = %v, want %v", tt.segments, tt.duplicates, got, tt.want)
+		}
+	}
+}
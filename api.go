@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiJobRequest is the body of POST /api/jobs. Input is either an HTTP(S)
+// URL, an "upload:" reference, or a path relative to --media-dir.
+type apiJobRequest struct {
+	Input     string `json:"input"`
+	Tracklist string `json:"tracklist"`
+	Format    string `json:"format"`
+}
+
+type apiJobStatus struct {
+	ID      string        `json:"id"`
+	Album   string        `json:"album"`
+	Phase   JobPhase      `json:"phase"`
+	Error   string        `json:"error,omitempty"`
+	Created time.Time     `json:"created"`
+	Done    int           `json:"done"`
+	Total   int           `json:"total"`
+	Tracks  []TrackReport `json:"tracks"`
+}
+
+func (s *server) apiRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/jobs", s.apiListJobs)
+	mux.HandleFunc("POST /api/jobs", s.apiCreateJob)
+	mux.HandleFunc("GET /api/jobs/{id}", s.apiGetJob)
+	mux.HandleFunc("DELETE /api/jobs/{id}", s.apiCancelJob)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.apiCancelJob)
+	mux.HandleFunc("GET /api/jobs/{id}/report", s.apiJobReport)
+	return mux
+}
+
+// requireToken rejects requests, to the web UI and the API alike, without the
+// configured token. With no token configured the server is open.
+func (s *server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.apiToken != "" && !s.validToken(r) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+				return
+			}
+			// Browsers ask for the token as a password.
+			w.Header().Set("WWW-Authenticate", `Basic realm="song-splitter"`)
+			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken reports whether r carries the token, as a bearer token or as
+// the password of basic authentication, with any user name.
+func (s *server) validToken(r *http.Request) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, got, _ = r.BasicAuth()
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(s.apiToken)) == 1
+}
+
+func (s *server) apiListJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.jobList()
+	statuses := make([]apiJobStatus, 0, len(jobs))
+	for _, sj := range jobs {
+		statuses = append(statuses, sj.apiStatus())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *server) apiCreateJob(w http.ResponseWriter, r *http.Request) {
+	var req apiJobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxFormField)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ref := req.Input
+	if !isURL(ref) && !hasRefPrefix(ref) {
+		ref = "media:" + ref
+	}
+
+	sj, err := s.submit(ref, req.Tracklist, req.Format)
+	if err != nil {
+		writeJSONError(w, statusFor(err), err)
+		return
+	}
+	w.Header().Set("Location", "/api/jobs/"+sj.ID)
+	writeJSON(w, http.StatusCreated, sj.apiStatus())
+}
+
+func (s *server) apiGetJob(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	writeJSON(w, http.StatusOK, sj.apiStatus())
+}
+
+func (s *server) apiCancelJob(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	sj.cancel()
+	writeJSON(w, http.StatusAccepted, sj.apiStatus())
+}
+
+func (s *server) apiJobReport(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		writeJSONError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	if phase := sj.Phase(); phase == PhaseQueued || phase == PhaseRunning {
+		writeJSONError(w, http.StatusConflict, errors.New("job is still "+string(phase)))
+		return
+	}
+	writeJSON(w, http.StatusOK, sj.Report())
+}
+
+func (sj *serverJob) apiStatus() apiJobStatus {
+	report := sj.Report()
+	done := 0
+	for _, t := range report.Tracks {
+		if t.Status == StatusDone || t.Status == StatusFailed {
+			done++
+		}
+	}
+	return apiJobStatus{
+		ID:      sj.ID,
+		Album:   sj.Album,
+		Phase:   sj.Phase(),
+		Error:   sj.Err(),
+		Created: sj.Created,
+		Done:    done,
+		Total:   len(report.Tracks),
+		Tracks:  report.Tracks,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// writeZip streams the outputs under dir (see archivedFiles) into a zip
+// archive written to w, storing entries under prefix/.
+func writeZip(w io.Writer, dir, prefix string) error {
+	files, err := archivedFiles(dir)
+	if err != nil {
+		return err
+	}
+	return writeZipFiles(w, dir, prefix, files)
+}
+
+func writeZipFiles(w io.Writer, dir, prefix string, files []string) error {
+	zw := zip.NewWriter(w)
+	for _, rel := range files {
+		err := copyFile(filepath.Join(dir, rel), func(info fs.FileInfo, r io.Reader) error {
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+			// Media is already compressed, so store rather than deflate.
+			hdr.Method = zip.Store
+
+			entry, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(entry, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeTarGzFiles is writeZipFiles for a gzipped tarball.
+func writeTarGzFiles(w io.Writer, dir, prefix string, files []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, rel := range files {
+		err := copyFile(filepath.Join(dir, rel), func(info fs.FileInfo, r io.Reader) error {
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// listFiles returns the regular files under dir, relative to it.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		// The run's own state is not one of its outputs.
+		if strings.HasPrefix(d.Name(), checkpointFile) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// archivedFiles is listFiles less what is not one of the run's outputs: the
+// ffmpeg logs, and archives, whether being written now or left by an earlier
+// run, which would otherwise nest.
+func archivedFiles(dir string) ([]string, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, rel := range files {
+		rel := filepath.ToSlash(rel)
+		if rel == logsDir || strings.HasPrefix(rel, logsDir+"/") || strings.Contains(rel, "/"+logsDir+"/") {
+			continue
+		}
+		if strings.HasSuffix(rel, "."+ArchiveZip) || strings.HasSuffix(rel, "."+ArchiveTarGz) {
+			continue
+		}
+		out = append(out, filepath.FromSlash(rel))
+	}
+	return out, nil
+}
+
+func copyFile(p string, write func(info fs.FileInfo, r io.Reader) error) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return write(info, f)
+}
+
+// archiveJob bundles the job's output directory, with a report.json, into
+// one archive named after the album inside that directory. With removeLoose
+// the archived files are deleted afterwards, leaving only the archive.
+func archiveJob(job *Job, removeLoose bool) (string, error) {
+	if _, err := writeReport(job); err != nil {
+		return "", err
+	}
+
+	files, err := archivedFiles(job.OutputDir)
+	if err != nil {
+		return "", err
+	}
+
+	name := job.Filenames.Sanitize(job.Album)
+	if name == "" {
+		name = "output"
+	}
+	dest := filepath.Join(job.OutputDir, name+"."+job.Archive)
+	err = writeFile(dest, func(f *os.File) error {
+		if job.Archive == ArchiveTarGz {
+			return writeTarGzFiles(f, job.OutputDir, name, files)
+		}
+		return writeZipFiles(f, job.OutputDir, name, files)
+	})
+	if err != nil {
+		os.Remove(dest)
+		return "", fmt.Errorf("write %s: %v", dest, err)
+	}
+
+	if removeLoose {
+		for _, rel := range files {
+			if err := os.Remove(filepath.Join(job.OutputDir, rel)); err != nil {
+				return dest, err
+			}
+		}
+	}
+	return dest, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestEntry is one set in a --batch manifest. Relative paths are
+// resolved against the manifest's directory.
+type ManifestEntry struct {
+	Input     string `json:"input"`
+	Tracklist string `json:"tracklist"`
+	Album     string `json:"album,omitempty"`
+	Overrides string `json:"overrides,omitempty"`
+	Disc      string `json:"disc,omitempty"`
+	Date      string `json:"date,omitempty"`
+	// RecordingStart is as --recording-start, for this set's tracklist.
+	RecordingStart string `json:"recording_start,omitempty"`
+	// Output names the job's folder under the output directory; it defaults
+	// to the album.
+	Output string `json:"output,omitempty"`
+}
+
+func loadManifest(path string) ([]ManifestEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ManifestEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse manifest: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("manifest lists no jobs")
+	}
+
+	base := filepath.Dir(path)
+	for i := range entries {
+		e := &entries[i]
+		if e.Input == "" || e.Tracklist == "" {
+			return nil, fmt.Errorf("manifest entry %d: input and tracklist are required", i+1)
+		}
+		if !isURL(e.Input) {
+			e.Input = resolvePath(base, e.Input)
+		}
+		e.Tracklist = resolvePath(base, e.Tracklist)
+		if e.Overrides != "" {
+			e.Overrides = resolvePath(base, e.Overrides)
+		}
+	}
+	return entries, nil
+}
+
+// planBatch plans every manifest entry up front so a broken tracklist is
+// reported before anything is encoded. Each job writes into its own folder
+// under base.OutputDir.
+func planBatch(entries []ManifestEntry, base Options) ([]*Job, error) {
+	var jobs []*Job
+	seen := make(map[string]int)
+	for i, e := range entries {
+		o := base
+		o.Input = e.Input
+		o.Tracklist = e.Tracklist
+		o.Overrides = e.Overrides
+		if e.Album != "" {
+			o.Album = e.Album
+		}
+		if e.Disc != "" {
+			o.Disc = e.Disc
+		}
+		if e.RecordingStart != "" {
+			o.RecordingStart = e.RecordingStart
+		}
+		if e.Date != "" {
+			o.Date = e.Date
+		}
+
+		job, err := planJobFile(o)
+		if err != nil {
+			return nil, fmt.Errorf("manifest entry %d (%s): %v", i+1, e.Tracklist, err)
+		}
+
+		dir := e.Output
+		if dir == "" {
+			dir = job.Album
+		}
+		dir = strings.TrimSpace(job.Filenames.Sanitize(dir))
+		if dir == "" {
+			dir = fmt.Sprintf("%02d", i+1)
+		}
+		if prev, ok := seen[strings.ToLower(dir)]; ok {
+			return nil, fmt.Errorf("manifest entries %d and %d both write to %q", prev, i+1, dir)
+		}
+		seen[strings.ToLower(dir)] = i + 1
+
+		job.OutputDir = filepath.Join(base.OutputDir, dir)
+		job.uploadPrefix = dir
+		createFilenames(job.Tracks, job.OutputDir, job.Ext, job.Filenames)
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strconv"
+)
+
+const (
+	// boundaryFrame is the number of alignRate samples each spectrum is
+	// taken over, about half a second.
+	boundaryFrame = 2048
+	// boundaryContext is how many seconds either side of a moment are
+	// compared to tell whether a new track starts there.
+	boundaryContext = 30
+	// boundaryMinTrack is the shortest track, in seconds, a detected
+	// boundary may leave.
+	boundaryMinTrack = 60
+	// boundaryMinConfidence is the confidence below which a detected start
+	// is still treated as an estimate.
+	boundaryMinConfidence = 0.9
+)
+
+// boundaryBands are the edges, in Hz, of the frequency bands whose energy
+// describes the music at each moment: kick and bass up to the top of the
+// mids.
+var boundaryBands = []float64{30, 80, 160, 320, 640, 1280, 2000}
+
+// detectBoundaries places the tracks without a timestamp at likely
+// transitions in the recording rather than spreading them evenly. It
+// measures how different the spectrum of the half-minute after each moment
+// is from the one before, and between each pair of known starts puts the
+// untimed tracks at the most distinct moments, in order and no closer than
+// boundaryMinTrack. A track placed at an unremarkable moment stays Estimated
+// so that it is flagged for checking.
+func detectBoundaries(tracks []Track, input, audioMap string, duration float64) error {
+	untimed := false
+	for _, t := range tracks {
+		untimed = untimed || t.Estimated
+	}
+	if !untimed || duration == 0 {
+		return nil
+	}
+
+	novelty, err := audioNovelty(input, audioMap)
+	if err != nil {
+		return err
+	}
+	hop := float64(boundaryFrame) / alignRate
+	peaks := noveltyPeaks(novelty, hop)
+	ranked := append([]float64(nil), novelty...)
+	sort.Float64s(ranked)
+	confidence := func(n float64) float64 {
+		return float64(sort.SearchFloat64s(ranked, n)) / float64(len(ranked))
+	}
+
+	for s := 0; s < len(tracks); s++ {
+		if !tracks[s].Estimated {
+			continue
+		}
+		e := s
+		for e < len(tracks) && tracks[e].Estimated {
+			e++
+		}
+		// Tracks s..e-1 are untimed. Without a start before them the
+		// first starts with the recording.
+		from, first := 0.0, s
+		if s > 0 {
+			from = tracks[s-1].StartTime
+		} else {
+			first = 1
+		}
+		to := duration
+		if e < len(tracks) {
+			to = tracks[e].StartTime
+		}
+
+		if first < e {
+			// Without room for them the even spread stands.
+			times := placeBoundaries(peaks, hop, novelty, from, to, e-first)
+			for k, at := range times {
+				t := &tracks[first+k]
+				t.StartTime = at
+				t.Detected = true
+				t.Confidence = confidence(novelty[int(at/hop)])
+				t.Estimated = t.Confidence < boundaryMinConfidence
+			}
+		}
+		s = e
+	}
+	return nil
+}
+
+// audioNovelty decodes the input's audio and scores every frame by how much
+// the music changes there, as the distance between the average band
+// energies of the boundaryContext seconds before and after it.
+func audioNovelty(input, audioMap string) ([]float64, error) {
+	args := []string{"-v", "error"}
+	args = append(args, inputArgs(input)...)
+	args = append(args, "-map", audioMap, "-ac", "1", "-ar", strconv.Itoa(alignRate), "-f", "s16le", "-")
+	bands := &bandWriter{}
+	var stderr bytes.Buffer
+	if err := runFFmpeg(context.Background(), &Command{Args: args, Stdout: bands, Stderr: &stderr}); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\nOutput: %s", err, stderr.String())
+	}
+	if len(bands.frames) == 0 {
+		return nil, fmt.Errorf("no audio to detect boundaries in")
+	}
+	return noveltyFromFrames(bands.frames), nil
+}
+
+// noveltyFromFrames scores frames of band energies.
+func noveltyFromFrames(frames [][]float64) []float64 {
+	// Scale each band by its spread, so the quiet top bands count as much
+	// as the bass.
+	nb := len(boundaryBands) - 1
+	for b := range nb {
+		var sum, sq float64
+		for _, f := range frames {
+			sum += f[b]
+			sq += f[b] * f[b]
+		}
+		mean := sum / float64(len(frames))
+		std := math.Sqrt(max(sq/float64(len(frames))-mean*mean, 1e-9))
+		for _, f := range frames {
+			f[b] = (f[b] - mean) / std
+		}
+	}
+
+	// Prefix sums give the average of any stretch of frames at once.
+	prefix := make([][]float64, len(frames)+1)
+	prefix[0] = make([]float64, nb)
+	for i, f := range frames {
+		prefix[i+1] = make([]float64, nb)
+		for b := range nb {
+			prefix[i+1][b] = prefix[i][b] + f[b]
+		}
+	}
+	w := int(boundaryContext * alignRate / boundaryFrame)
+	novelty := make([]float64, len(frames))
+	for i := range frames {
+		lo, hi := max(i-w, 0), min(i+w, len(frames))
+		if i-lo == 0 || hi-i == 0 {
+			continue
+		}
+		var d float64
+		for b := range nb {
+			before := (prefix[i][b] - prefix[lo][b]) / float64(i-lo)
+			after := (prefix[hi][b] - prefix[i][b]) / float64(hi-i)
+			d += (after - before) * (after - before)
+		}
+		novelty[i] = math.Sqrt(d)
+	}
+	return novelty
+}
+
+// noveltyPeaks are the frames scoring highest within boundaryContext/2
+// seconds either side, the candidates for a boundary.
+func noveltyPeaks(novelty []float64, hop float64) []int {
+	r := int(boundaryContext / 2 / hop)
+	var peaks []int
+	for i, n := range novelty {
+		if n == 0 {
+			continue
+		}
+		peak := true
+		for j := max(i-r, 0); j < min(i+r+1, len(novelty)) && peak; j++ {
+			peak = novelty[j] < n || (novelty[j] == n && j >= i)
+		}
+		if peak {
+			peaks = append(peaks, i)
+		}
+	}
+	return peaks
+}
+
+// placeBoundaries picks count of the peaks strictly between from and to,
+// keeping every track at least boundaryMinTrack long (or an equal share of
+// a shorter stretch), with the highest total novelty. It returns nil if
+// they cannot be fitted.
+func placeBoundaries(peaks []int, hop float64, novelty []float64, from, to float64, count int) []float64 {
+	minLen := min(boundaryMinTrack, (to-from)/float64(count+1)/2)
+	var cands []int
+	for _, p := range peaks {
+		if at := float64(p) * hop; at-from >= minLen && to-at >= minLen {
+			cands = append(cands, p)
+		}
+	}
+
+	// best[k][j] is the highest total for k+1 boundaries, the last at
+	// cands[j]; prev records the boundary before it.
+	best := make([][]float64, count)
+	prev := make([][]int, count)
+	for k := range count {
+		best[k] = make([]float64, len(cands))
+		prev[k] = make([]int, len(cands))
+		for j, c := range cands {
+			best[k][j], prev[k][j] = math.Inf(-1), -1
+			if k == 0 {
+				best[k][j] = novelty[c]
+				continue
+			}
+			for l := range j {
+				if float64(c-cands[l])*hop >= minLen && best[k-1][l] > math.Inf(-1) && best[k-1][l]+novelty[c] > best[k][j] {
+					best[k][j], prev[k][j] = best[k-1][l]+novelty[c], l
+				}
+			}
+		}
+	}
+
+	last := -1
+	for j := range cands {
+		if best[count-1][j] > math.Inf(-1) && (last < 0 || best[count-1][j] > best[count-1][last]) {
+			last = j
+		}
+	}
+	if last < 0 {
+		return nil
+	}
+	times := make([]float64, count)
+	for k := count - 1; k >= 0; k-- {
+		times[k] = float64(cands[last]) * hop
+		last = prev[k][last]
+	}
+	return times
+}
+
+// bandWriter takes s16le alignRate samples and records the log energy of
+// each boundaryBands band in every boundaryFrame of them.
+type bandWriter struct {
+	pending []byte
+	frame   []complex128
+	frames  [][]float64
+}
+
+func (w *bandWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for len(w.pending) >= 2*boundaryFrame {
+		if w.frame == nil {
+			w.frame = make([]complex128, boundaryFrame)
+		}
+		for i := range w.frame {
+			w.frame[i] = complex(float64(int16(binary.LittleEndian.Uint16(w.pending[2*i:]))), 0)
+		}
+		w.pending = w.pending[2*boundaryFrame:]
+		fft(w.frame, false)
+
+		energies := make([]float64, len(boundaryBands)-1)
+		binHz := float64(alignRate) / boundaryFrame
+		for b := range energies {
+			lo, hi := int(boundaryBands[b]/binHz), int(boundaryBands[b+1]/binHz)
+			var e float64
+			for k := lo; k < hi && k < boundaryFrame/2; k++ {
+				m := cmplx.Abs(w.frame[k])
+				e += m * m
+			}
+			energies[b] = math.Log10(e + 1)
+		}
+		w.frames = append(w.frames, energies)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setCancel makes cancelling cmd's context interrupt it, as Ctrl+C would,
+// so ffmpeg closes its output before exiting. The nice and ulimit wrappers
+// exec the real command, so the signal reaches it.
+func setCancel(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = cancelGrace
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+// setCancel makes cancelling cmd's context end it together with any
+// processes it started, such as the commands of a cmd /C hook. Windows has
+// no interrupt to send another console process, and killing only the
+// parent would leave the children running and holding their files open.
+func setCancel(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = cancelGrace
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkpointFile is the state file kept in each output directory, recording
+// which tracks are done so that --resume can skip them.
+const checkpointFile = ".song-splitter-state.json"
+
+type checkpoint struct {
+	// Plan is the hash of the plan the tracks were split under; a resumed
+	// run must arrive at the same one.
+	Plan   string            `json:"plan"`
+	Tracks []checkpointTrack `json:"tracks"`
+}
+
+type checkpointTrack struct {
+	Output string      `json:"output"`
+	Status TrackStatus `json:"status"`
+}
+
+// planHash identifies what a job will write: its tracks with their cut
+// points, tags and filenames, the options that shape the outputs, and the
+// input's size and length. Planning is deterministic, so the same flags,
+// tracklist and input give the same hash.
+func planHash(j *Job) (string, error) {
+	o := j.Options
+	// Neither where the plan came from nor what happens around the
+	// encodes changes the outputs. A buffered stdin gets a new temporary
+	// path each run.
+	o.Input, o.InputParts, o.Tracklist, o.LyricsCache = "", nil, "", ""
+	o.PreTrackHook, o.PostTrackHook, o.PostRunHook = "", "", ""
+	o.NotifyURL, o.NotifyDesktop, o.FailFast = "", false, false
+	plan := struct {
+		Options
+		Source   string
+		Size     int64
+		Duration float64
+		Ext      string
+		Tracks   []Track
+	}{Options: o, Duration: j.Duration, Ext: j.Ext, Tracks: j.Tracks}
+	if isURL(j.Input) {
+		plan.Source = j.Input
+	} else if len(j.InputParts) > 0 {
+		for _, part := range j.InputParts {
+			if info, err := os.Stat(part); err == nil {
+				plan.Size += info.Size()
+			}
+		}
+	} else if info, err := os.Stat(j.Input); err == nil {
+		plan.Size = info.Size()
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// openCheckpoint starts recording the job's progress in its output
+// directory. With resume, tracks a previous run of the same plan finished,
+// and whose outputs are still there, are marked to be skipped.
+func (j *Job) openCheckpoint(resume bool) error {
+	hash, err := planHash(j)
+	if err != nil {
+		return err
+	}
+	j.plan = hash
+	j.resumed = make([]bool, len(j.Tracks))
+	if !resume {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(j.OutputDir, checkpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return fmt.Errorf("read %s: %v", checkpointFile, err)
+	}
+	if cp.Plan != hash {
+		return fmt.Errorf("%s was split with a different plan; the flags, tracklist or input have changed since", j.OutputDir)
+	}
+	for i, t := range cp.Tracks {
+		if i < len(j.Tracks) && t.Status == StatusDone && j.outputsExist(i) {
+			j.resumed[i] = true
+		}
+	}
+	return nil
+}
+
+// outputsExist reports whether track i's output, and those its command
+// writes for sibling formats, are on disk.
+func (j *Job) outputsExist(i int) bool {
+	if !j.encodes() {
+		return true
+	}
+	for _, job := range append([]*Job{j}, j.siblings...) {
+		if !fileExists(job.Tracks[i].OutputFilename) {
+			return false
+		}
+	}
+	return true
+}
+
+// wasResumed reports whether track i was done by an earlier run.
+func (j *Job) wasResumed(i int) bool {
+	return j.resumed != nil && j.resumed[i]
+}
+
+// saveCheckpoint records the state of every track. It writes a temporary
+// file, syncs it and renames it over the old one, so a crash leaves either
+// the old state or the new.
+func (j *Job) saveCheckpoint() error {
+	if j.plan == "" {
+		return nil
+	}
+	j.checkpointMu.Lock()
+	defer j.checkpointMu.Unlock()
+
+	cp := checkpoint{Plan: j.plan}
+	for i, st := range j.States() {
+		cp.Tracks = append(cp.Tracks, checkpointTrack{Output: filepath.Base(j.Tracks[i].OutputFilename), Status: st.Status})
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(j.OutputDir, checkpointFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(j.OutputDir, checkpointFile))
+}
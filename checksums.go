@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ChecksumsManifest writes one SHA256SUMS file per output directory.
+	ChecksumsManifest = "sums"
+	// ChecksumsFiles writes a .sha256 file next to every output.
+	ChecksumsFiles = "files"
+)
+
+// checksumManifest is the file name sha256sum -c expects by convention.
+const checksumManifest = "SHA256SUMS"
+
+// writeChecksums hashes the finished outputs, their sidecar files and the
+// source file, keeping the sums for the report, and writes them in the
+// sha256sum format so "sha256sum -c" can verify the set later.
+func writeChecksums(job *Job, logger *slog.Logger) error {
+	states := job.States()
+	sums := make([]string, len(job.Tracks))
+	var lines []string
+
+	add := func(path, name string) (string, error) {
+		sum, err := hashFile(path)
+		if err != nil {
+			return "", err
+		}
+		line := fmt.Sprintf("%s  %s\n", sum, name)
+		lines = append(lines, line)
+		if job.Checksums == ChecksumsFiles {
+			if err := os.WriteFile(path+".sha256", []byte(fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))), 0644); err != nil {
+				return "", err
+			}
+		}
+		return sum, nil
+	}
+
+	for i, t := range job.Tracks {
+		if states[i].Status != StatusDone {
+			continue
+		}
+		sum, err := add(t.OutputFilename, filepath.Base(t.OutputFilename))
+		if err != nil {
+			return err
+		}
+		sums[i] = sum
+
+		for _, p := range trackSidecars(&t) {
+			if _, err := add(p, filepath.Base(p)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The source usually lives elsewhere, so it is listed by absolute path,
+	// and gets no .sha256 file of its own. Remote inputs are not hashed, and
+	// of one in parts each part is.
+	var sourceSum string
+	if len(job.InputParts) > 0 {
+		for _, part := range job.InputParts {
+			source, err := filepath.Abs(part)
+			if err != nil {
+				return err
+			}
+			sum, err := hashFile(source)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%s  %s\n", sum, source))
+		}
+	} else if !isURL(job.Input) {
+		source, err := filepath.Abs(job.Input)
+		if err != nil {
+			return err
+		}
+		if sourceSum, err = hashFile(source); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s\n", sourceSum, source))
+	}
+
+	if job.Checksums == ChecksumsManifest {
+		path := filepath.Join(job.OutputDir, checksumManifest)
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+			return err
+		}
+		logger.Info("Wrote checksums", "path", path, "files", len(lines))
+	}
+
+	job.mu.Lock()
+	job.sums = sums
+	job.sourceSum = sourceSum
+	job.mu.Unlock()
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
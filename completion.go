@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// completionShells are the shells the completion subcommand writes scripts
+// for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// flagValues are the completions of flags with a fixed set of values. A
+// "command flag" key applies to that subcommand only.
+var flagValues = map[string][]string{
+	"format":           append(append([]string(nil), audioFormats...), videoFormats...),
+	"video-codec":      {CodecVP9, CodecAV1},
+	"channels":         {ChannelsMono, ChannelsStereo, ChannelsKeep},
+	"sample-rate":      {SampleRateKeep, "44100", "48000"},
+	"segments":         {SegmentsExclude, SegmentsBonus, SegmentsInclude},
+	"lyrics":           {LyricsEmbed, LyricsLRC, LyricsBoth},
+	"waveforms":        {WaveformsTracks, WaveformsSet, WaveformsBoth},
+	"checksums":        {ChecksumsManifest, ChecksumsFiles},
+	"archive":          {ArchiveZip, ArchiveTarGz},
+	"filenames":        {FilenamesDefault, FilenamesWindows, FilenamesASCII},
+	"id3-version":      {"3", "4"},
+	"id3-encoding":     {"utf8", "utf16"},
+	"priority":         {PriorityNormal, PriorityLow, PriorityIdle},
+	"overlay-position": {OverlayBottomLeft, OverlayBottomRight, OverlayTopLeft, OverlayTopRight},
+	"rotate":           {"90", "180", "270"},
+	"duplicates":       {DuplicatesMerge, DuplicatesError},
+	"cues format":      cueFormats(),
+}
+
+// fileFlags are the flags that take a file or directory path.
+var fileFlags = map[string]bool{
+	"tracklist": true, "input": true, "overrides": true, "batch": true, "align": true,
+	"lyrics-cache": true, "output": true, "dir": true, "out": true, "report": true,
+	"data-dir": true, "media-dir": true, "overlay-font": true,
+}
+
+// completionArgs are the completions of a subcommand's positional argument.
+var completionArgs = map[string][]string{
+	"completion": completionShells,
+}
+
+func init() {
+	// Registered here rather than in the subcommands literal, which the
+	// completion command reads.
+	subcommands["completion"] = subcommand{"Print a bash, zsh or fish completion script", completionCommand}
+}
+
+// completionCommand prints a completion script for the shell named by its
+// argument, covering the subcommands, their flags and the flags' values.
+func completionCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: song-splitter completion <%s>\n", strings.Join(completionShells, "|"))
+	}
+	return func(logger *slog.Logger) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("expected a shell name")
+		}
+		cmds := completionCommands()
+		switch flags.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout, cmds)
+		case "zsh":
+			// zsh runs bash completion functions through bashcompinit.
+			fmt.Fprintln(os.Stdout, "autoload -U +X bashcompinit && bashcompinit")
+			writeBashCompletion(os.Stdout, cmds)
+		case "fish":
+			writeFishCompletion(os.Stdout, cmds)
+		default:
+			return fmt.Errorf("unknown shell %q: want %s", flags.Arg(0), strings.Join(completionShells, ", "))
+		}
+		return nil
+	}
+}
+
+// completionCmd is a command's flags as completion scripts need them. The
+// split command has an empty name.
+type completionCmd struct {
+	name  string
+	flags []completionFlag
+}
+
+type completionFlag struct {
+	name  string
+	usage string
+	// value is set for flags that take a value, as opposed to switches.
+	value  bool
+	files  bool
+	values []string
+}
+
+func completionCommands() []completionCmd {
+	cmds := []completionCmd{{flags: completionFlags("", flag.CommandLine)}}
+	for _, name := range subcommandNames() {
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		subcommands[name].setup(fs)
+		cmds = append(cmds, completionCmd{name: name, flags: completionFlags(name, fs)})
+	}
+	return cmds
+}
+
+func completionFlags(cmd string, fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		cf := completionFlag{name: f.Name, usage: f.Usage, value: !ok || !b.IsBoolFlag(), files: fileFlags[f.Name]}
+		if values, ok := flagValues[cmd+" "+f.Name]; ok {
+			cf.values = values
+		} else if cmd == "" || cf.name != "format" {
+			cf.values = flagValues[f.Name]
+		}
+		flags = append(flags, cf)
+	})
+	return flags
+}
+
+// writeBashCompletion writes a completion function for bash, which also
+// serves zsh. Values of comma-separated flags such as --format complete
+// after each comma.
+func writeBashCompletion(w io.Writer, cmds []completionCmd) {
+	var names []string
+	for _, c := range cmds[1:] {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintln(w, "# song-splitter completion")
+	fmt.Fprintln(w, "_song_splitter() {")
+	fmt.Fprintln(w, `	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd= pre=`)
+	fmt.Fprintf(w, "\tcase ${COMP_WORDS[1]} in\n\t%s) cmd=${COMP_WORDS[1]} ;;\n\tesac\n", strings.Join(names, "|"))
+	fmt.Fprintln(w, `	if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\tfi\n", strings.Join(names, " "))
+	fmt.Fprintln(w, `	[[ $cur == *,* ]] && pre=${cur%,*},`)
+	fmt.Fprintln(w, `	prev=${prev#--}`)
+	fmt.Fprintln(w, `	case "$cmd ${prev#-}" in`)
+	for _, c := range cmds {
+		for _, f := range c.flags {
+			switch {
+			case len(f.values) > 0:
+				fmt.Fprintf(w, "\t%q) COMPREPLY=($(compgen -P \"$pre\" -W %q -- \"${cur##*,}\")); return ;;\n",
+					c.name+" "+f.name, strings.Join(f.values, " "))
+			case f.files:
+				fmt.Fprintf(w, "\t%q) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", c.name+" "+f.name)
+			case f.value:
+				fmt.Fprintf(w, "\t%q) return ;;\n", c.name+" "+f.name)
+			}
+		}
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "\tlocal flags")
+	fmt.Fprintln(w, "\tcase $cmd in")
+	for _, c := range cmds {
+		var flags []string
+		for _, f := range c.flags {
+			flags = append(flags, "--"+f.name)
+		}
+		label := c.name
+		if label == "" {
+			label = `""`
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", label, strings.Join(flags, " "))
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ $cur == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	for cmd, args := range completionArgs {
+		fmt.Fprintf(w, "\telif [[ $cmd == %s ]]; then\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", cmd, strings.Join(args, " "))
+	}
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _song_splitter song-splitter")
+}
+
+// writeFishCompletion writes fish completions, with each flag's usage as its
+// description.
+func writeFishCompletion(w io.Writer, cmds []completionCmd) {
+	var names []string
+	for _, c := range cmds[1:] {
+		names = append(names, c.name)
+	}
+	quote := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+	}
+
+	fmt.Fprintln(w, "# song-splitter completion")
+	fmt.Fprintln(w, "complete -c song-splitter -f")
+	for _, c := range cmds[1:] {
+		fmt.Fprintf(w, "complete -c song-splitter -n __fish_use_subcommand -a %s -d %s\n", c.name, quote(subcommands[c.name].summary))
+	}
+	for _, c := range cmds {
+		cond := "not __fish_seen_subcommand_from " + strings.Join(names, " ")
+		if c.name != "" {
+			cond = "__fish_seen_subcommand_from " + c.name
+		}
+		for _, f := range c.flags {
+			opts := ""
+			switch {
+			case len(f.values) > 0:
+				opts = " -x -a " + quote(strings.Join(f.values, " "))
+			case f.files:
+				opts = " -r -F"
+			case f.value:
+				opts = " -x"
+			}
+			fmt.Fprintf(w, "complete -c song-splitter -n %s -l %s%s -d %s\n", quote(cond), f.name, opts, quote(f.usage))
+		}
+		if args, ok := completionArgs[c.name]; ok {
+			fmt.Fprintf(w, "complete -c song-splitter -n %s -a %s\n", quote(cond), quote(strings.Join(args, " ")))
+		}
+	}
+}
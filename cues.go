@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rekordboxHotCues is how many hot cue pads Rekordbox has; later tracks only
+// get memory cues.
+const rekordboxHotCues = 8
+
+// cueMix is a full recording with its track boundaries, as DJ software
+// would import it.
+type cueMix struct {
+	// Path is the absolute path of the recording.
+	Path     string
+	Album    string
+	Duration float64
+	Tracks   []Track
+}
+
+// cueWriters are the supported --format values of the cues subcommand.
+var cueWriters = map[string]func(w io.Writer, mix *cueMix) error{
+	"rekordbox": writeRekordboxXML,
+	"traktor":   writeTraktorNML,
+}
+
+// cuesCommand writes the tracklist as cue points on the original recording,
+// so the whole mix opens in DJ software with every boundary marked. Like the
+// other subcommands it registers its flags and returns the function run once
+// they are parsed.
+func cuesCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	tracklistPath := flags.String("tracklist", "", "Tracklist of the recording")
+	input := flags.String("input", "", "The recording the cues are for")
+	overrides := flags.String("overrides", "", "Overrides file applied to the tracklist")
+	format := flags.String("format", "rekordbox", "Cue format: "+strings.Join(cueFormats(), ", "))
+	output := flags.String("output", "", "File to write (default: stdout)")
+	return func(logger *slog.Logger) error {
+		write, ok := cueWriters[*format]
+		if !ok {
+			return fmt.Errorf("unknown cue format %q: want %s", *format, strings.Join(cueFormats(), " or "))
+		}
+		if *tracklistPath == "" || *input == "" {
+			return errors.New("both --tracklist and --input are required")
+		}
+		if isURL(*input) {
+			return errors.New("--input must be a local file for DJ software to open")
+		}
+		path, err := filepath.Abs(*input)
+		if err != nil {
+			return err
+		}
+
+		job, err := planJobFile(Options{Tracklist: *tracklistPath, Input: path, Overrides: *overrides, Audio: true})
+		if err != nil {
+			return err
+		}
+		for _, d := range job.Warnings {
+			logger.Warn("Tracklist warning", "line", d.Line, "problem", d.Message, "fix", d.Fix)
+		}
+		mix := &cueMix{Path: path, Album: job.Album, Duration: job.Duration, Tracks: job.Tracks}
+
+		w := io.Writer(os.Stdout)
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := write(w, mix); err != nil {
+			return err
+		}
+		logger.Info("Wrote cue points", "format", *format, "cues", len(mix.Tracks))
+		return nil
+	}
+}
+
+func cueFormats() []string {
+	formats := make([]string, 0, len(cueWriters))
+	for f := range cueWriters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// cueName labels a cue with the track it starts.
+func cueName(t *Track) string {
+	return t.MainArtist + " - " + buildTitle(t)
+}
+
+// fileURL is the file://localhost/ form DJ software uses for library paths.
+// A UNC path names its server as the host instead, as in
+// file://nas/music/mix.mp3.
+func fileURL(path string) string {
+	u := url.URL{Scheme: "file", Host: "localhost", Path: filepath.ToSlash(path)}
+	if vol := filepath.VolumeName(path); len(vol) > 2 && os.IsPathSeparator(vol[0]) {
+		server, _, _ := strings.Cut(filepath.ToSlash(vol)[2:], "/")
+		u.Host = server
+		u.Path = filepath.ToSlash(path)[2+len(server):]
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		// Windows drive paths, as in file://localhost/C:/Music/mix.mp3.
+		u.Path = "/" + u.Path
+	}
+	return u.String()
+}
+
+type rekordboxXML struct {
+	XMLName    xml.Name `xml:"DJ_PLAYLISTS"`
+	Version    string   `xml:"Version,attr"`
+	Product    rekordboxProduct
+	Collection rekordboxCollection
+	Playlists  rekordboxPlaylists
+}
+
+type rekordboxProduct struct {
+	XMLName xml.Name `xml:"PRODUCT"`
+	Name    string   `xml:"Name,attr"`
+}
+
+type rekordboxCollection struct {
+	XMLName xml.Name         `xml:"COLLECTION"`
+	Entries int              `xml:"Entries,attr"`
+	Tracks  []rekordboxTrack `xml:"TRACK"`
+}
+
+// rekordboxPlaylists is the empty playlist tree Rekordbox expects.
+type rekordboxPlaylists struct {
+	XMLName xml.Name `xml:"PLAYLISTS"`
+	Root    struct {
+		Type  int    `xml:"Type,attr"`
+		Name  string `xml:"Name,attr"`
+		Count int    `xml:"Count,attr"`
+	} `xml:"NODE"`
+}
+
+type rekordboxTrack struct {
+	TrackID   int             `xml:"TrackID,attr"`
+	Name      string          `xml:"Name,attr"`
+	Album     string          `xml:"Album,attr"`
+	TotalTime int             `xml:"TotalTime,attr"`
+	Location  string          `xml:"Location,attr"`
+	Marks     []rekordboxMark `xml:"POSITION_MARK"`
+}
+
+type rekordboxMark struct {
+	Name  string `xml:"Name,attr"`
+	Type  int    `xml:"Type,attr"`
+	Start string `xml:"Start,attr"`
+	// Num is the hot cue pad, or -1 for a memory cue.
+	Num int `xml:"Num,attr"`
+}
+
+// writeRekordboxXML writes a Rekordbox collection holding the recording with
+// a memory cue at every track and hot cues on the first eight. It is
+// imported from the "rekordbox xml" entry of Rekordbox's library settings.
+func writeRekordboxXML(w io.Writer, mix *cueMix) error {
+	track := rekordboxTrack{
+		TrackID:   1,
+		Name:      mix.Album,
+		Album:     mix.Album,
+		TotalTime: int(mix.Duration),
+		Location:  fileURL(mix.Path),
+	}
+	for i := range mix.Tracks {
+		t := &mix.Tracks[i]
+		start := fmt.Sprintf("%.3f", t.StartTime)
+		track.Marks = append(track.Marks, rekordboxMark{Name: cueName(t), Start: start, Num: -1})
+		if i < rekordboxHotCues {
+			track.Marks = append(track.Marks, rekordboxMark{Name: cueName(t), Start: start, Num: i})
+		}
+	}
+
+	doc := rekordboxXML{
+		Version:    "1.0.0",
+		Product:    rekordboxProduct{Name: "song-splitter"},
+		Collection: rekordboxCollection{Entries: 1, Tracks: []rekordboxTrack{track}},
+	}
+	doc.Playlists.Root.Name = "ROOT"
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestFileURL(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		windows bool
+	}{
+		{path: "/home/me/Music/mix.mp3", want: "file://localhost/home/me/Music/mix.mp3"},
+		{path: "/home/me/My Sets/a#1.mp3", want: "file://localhost/home/me/My%20Sets/a%231.mp3"},
+		{path: `C:\Music\mix.mp3`, want: "file://localhost/C:/Music/mix.mp3", windows: true},
+		{path: `\\nas\music\mix.mp3`, want: "file://nas/music/mix.mp3", windows: true},
+	}
+	for _, tt := range tests {
+		if tt.windows != (runtime.GOOS == "windows") {
+			continue
+		}
+		if got := fileURL(tt.path); got != tt.want {
+			t.Errorf("fileURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
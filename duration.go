@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// estimatedFormats are the ffprobe formats without an index of their own,
+// such as radio and stream rips, whose length ffprobe estimates from the
+// bitrate. For a VBR file, or one with a broken header, the estimate can be
+// minutes out.
+var estimatedFormats = map[string]bool{"mp3": true, "aac": true, "ac3": true, "eac3": true, "mpegts": true, "flv": true}
+
+// headerTolerance is how far, in seconds, the container's length may be
+// from the measured one before it is reported as wrong.
+const headerTolerance = 1.0
+
+// mediaDuration is the input's length. A local input without a length in
+// its container, in one of the estimatedFormats, or with measure, has its
+// audio read through to measure it instead; reported is then the
+// container's length if that was wrong, and 0 otherwise. A remote input
+// without a length, as live streams have, is an error.
+func mediaDuration(input string, measure bool) (duration, reported float64, err error) {
+	args := []string{"-v", "error", "-show_entries", "format=duration,format_name", "-of", "json"}
+	output, err := executor.Probe(context.Background(), append(args, inputArgs(input)...)...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ffprobe error: %v", err)
+	}
+	var probe struct {
+		Format struct {
+			Name     string `json:"format_name"`
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return 0, 0, fmt.Errorf("ffprobe output: %v", err)
+	}
+
+	known := probe.Format.Duration != "" && probe.Format.Duration != "N/A"
+	if known {
+		if duration, err = strconv.ParseFloat(probe.Format.Duration, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if isURL(input) {
+		if !known {
+			return 0, 0, fmt.Errorf("ffprobe could not determine the duration of %s", input)
+		}
+		if !measure {
+			return duration, 0, nil
+		}
+	} else if known && !measure && !estimatedFormats[probe.Format.Name] {
+		return duration, 0, nil
+	}
+
+	measured, err := measureDuration(input)
+	if err != nil {
+		return 0, 0, err
+	}
+	if known && math.Abs(measured-duration) > headerTolerance {
+		reported = duration
+	}
+	return measured, reported, nil
+}
+
+// measureDuration reads the input's first audio stream through, copying
+// rather than decoding it, and returns where it ends.
+func measureDuration(input string) (float64, error) {
+	args := []string{"-v", "error"}
+	args = append(args, inputArgs(input)...)
+	args = append(args, "-map", "0:a:0", "-c", "copy", "-f", "null", "-")
+	var end time.Duration
+	var stderr bytes.Buffer
+	err := runFFmpeg(context.Background(), &Command{Args: args, Stderr: &stderr, Progress: func(done time.Duration) {
+		end = max(end, done)
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("measuring the duration of %s: ffmpeg error: %v\n%s", input, err, strings.TrimSpace(stderr.String()))
+	}
+	if end == 0 {
+		return 0, fmt.Errorf("could not measure the duration of %s", input)
+	}
+	return end.Seconds(), nil
+}
+
+// durationWarning reports a container whose length was wrong, on the last
+// track, which is the one it would have cut short or run past the end.
+func durationWarning(tracks []Track, reported, duration float64) Diagnostic {
+	return Diagnostic{
+		Severity: SeverityWarning,
+		Line:     tracks[len(tracks)-1].Line,
+		Message: fmt.Sprintf("the input's header gives its length as %s, but its audio runs for %s; the measured length is used",
+			formatTimestamp(reported), formatTimestamp(duration)),
+		Fix: "remux the input to repair its header if other players get the length wrong too",
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMediaDuration(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		probe        string
+		measured     []time.Duration
+		measure      bool
+		wantDuration float64
+		wantReported float64
+		wantMeasured bool
+		wantErr      bool
+	}{
+		{
+			name:         "indexed container is trusted",
+			input:        "set.mkv",
+			probe:        `{"format":{"format_name":"matroska,webm","duration":"3600.5"}}`,
+			wantDuration: 3600.5,
+		},
+		{
+			name:         "estimated length is measured",
+			input:        "set.mp3",
+			probe:        `{"format":{"format_name":"mp3","duration":"3600.0"}}`,
+			measured:     []time.Duration{time.Hour, time.Hour + 2*time.Minute},
+			wantDuration: 3720,
+			wantReported: 3600,
+			wantMeasured: true,
+		},
+		{
+			name:         "estimate within tolerance is not reported",
+			input:        "set.mp3",
+			probe:        `{"format":{"format_name":"mp3","duration":"3600.0"}}`,
+			measured:     []time.Duration{3600500 * time.Millisecond},
+			wantDuration: 3600.5,
+			wantMeasured: true,
+		},
+		{
+			name:         "missing length is measured",
+			input:        "set.mkv",
+			probe:        `{"format":{"format_name":"matroska,webm","duration":"N/A"}}`,
+			measured:     []time.Duration{90 * time.Second},
+			wantDuration: 90,
+			wantMeasured: true,
+		},
+		{
+			name:         "measure flag",
+			input:        "set.mkv",
+			probe:        `{"format":{"format_name":"matroska,webm","duration":"100"}}`,
+			measured:     []time.Duration{120 * time.Second},
+			measure:      true,
+			wantDuration: 120,
+			wantReported: 100,
+			wantMeasured: true,
+		},
+		{
+			name:         "remote estimate is not measured",
+			input:        "https://example.com/live.mp3",
+			probe:        `{"format":{"format_name":"mp3","duration":"60"}}`,
+			wantDuration: 60,
+		},
+		{
+			name:    "remote without a length",
+			input:   "https://example.com/live.mp3",
+			probe:   `{"format":{"format_name":"mp3"}}`,
+			wantErr: true,
+		},
+		{
+			name:         "nothing measured",
+			input:        "set.mp3",
+			probe:        `{"format":{"format_name":"mp3","duration":"60"}}`,
+			wantMeasured: true,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useExecutor(t, &fakeExecutor{
+				ffprobe: []fakeProbe{{match: []string{"format=duration,format_name"}, output: tt.probe}},
+				ffmpeg:  []fakeRun{{match: []string{"null"}, progress: tt.measured}},
+			})
+			duration, reported, err := mediaDuration(tt.input, tt.measure)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mediaDuration() error = %v, want error %v", err, tt.wantErr)
+			}
+			if measured := f.ran("-f", "null") > 0; measured != tt.wantMeasured {
+				t.Errorf("measured = %v, want %v", measured, tt.wantMeasured)
+			}
+			if tt.wantErr {
+				return
+			}
+			if duration != tt.wantDuration || reported != tt.wantReported {
+				t.Errorf("mediaDuration() = %v, %v, want %v, %v", duration, reported, tt.wantDuration, tt.wantReported)
+			}
+		})
+	}
+}
+
+func TestMediaDurationFailures(t *testing.T) {
+	useExecutor(t, &fakeExecutor{
+		ffprobe: []fakeProbe{{match: []string{"-show_entries"}, output: `{"format":{"format_name":"mp3","duration":"60"}}`}},
+		ffmpeg:  []fakeRun{{match: []string{"null"}, stderr: "Invalid data found", err: errors.New("exit status 1")}},
+	})
+	if _, _, err := mediaDuration("set.mp3", false); err == nil {
+		t.Error("mediaDuration() succeeded although measuring failed")
+	}
+
+	useExecutor(t, &fakeExecutor{
+		ffprobe: []fakeProbe{{match: []string{"-show_entries"}, err: errors.New("exit status 1")}},
+	})
+	if _, _, err := mediaDuration("set.mkv", false); err == nil {
+		t.Error("mediaDuration() succeeded although ffprobe failed")
+	}
+}
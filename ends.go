@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Handling of consecutive tracks with the same start time.
+const (
+	// DuplicatesMerge, the default, folds such a track into the one before
+	// as a w/ track, as two tracks played together.
+	DuplicatesMerge = "merge"
+	// DuplicatesError reports such a track as having no length.
+	DuplicatesError = "error"
+)
+
+func validDuplicatesMode(mode string) bool {
+	switch mode {
+	case "", DuplicatesMerge, DuplicatesError:
+		return true
+	}
+	return false
+}
+
+// EndRules trims where a track ends, which is otherwise where the next one
+// starts. The zero value leaves it there.
+type EndRules struct {
+	// MaxLength caps the track's length; 0 leaves it uncapped.
+	MaxLength time.Duration
+	// Gap is left out between the track's end and the next track's start,
+	// such as applause or a host talking.
+	Gap time.Duration
+}
+
+func (r EndRules) validate() error {
+	if r.MaxLength < 0 || r.Gap < 0 {
+		return fmt.Errorf("track lengths and gaps cannot be negative")
+	}
+	return nil
+}
+
+// calculateEndTimes ends each track where the next one starts, less its
+// Gap, and no more than its MaxLength after its start. An end already set,
+// by an override or at an excluded segment, replaces the next start but is
+// still trimmed by the Gap and MaxLength. Unless duplicates is
+// DuplicatesError, tracks starting when the track before does are merged
+// into it first, rather than each left with no length; the merged tracks are
+// returned.
+func calculateEndTimes(tracks []Track, duration float64, duplicates string) []Track {
+	if duplicates != DuplicatesError {
+		tracks = mergeDuplicateStarts(tracks)
+	}
+	for i := range tracks {
+		t := &tracks[i]
+		atEnd := false
+		if t.EndTime == 0 {
+			if i < len(tracks)-1 {
+				t.EndTime = tracks[i+1].StartTime
+			} else {
+				t.EndTime, atEnd = duration, true
+			}
+		}
+		// Nothing follows the end of the media to leave a gap before, and a
+		// gap as long as the track itself is not left.
+		if gap := t.Ends.Gap.Seconds(); !atEnd && t.EndTime-gap > t.StartTime {
+			t.EndTime -= gap
+		}
+		if limit := t.Ends.MaxLength.Seconds(); limit > 0 && t.EndTime > t.StartTime+limit {
+			t.EndTime = t.StartTime + limit
+		}
+	}
+	return tracks
+}
+
+// mergeDuplicateStarts folds every track starting at the same time as the
+// one before into it as a w/ track, recording its line in Merged. Segments
+// are neither merged nor merged into.
+func mergeDuplicateStarts(tracks []Track) []Track {
+	var merged []Track
+	for _, t := range tracks {
+		n := len(merged)
+		if n == 0 || t.StartTime != merged[n-1].StartTime || t.Segment != "" || merged[n-1].Segment != "" {
+			merged = append(merged, t)
+			continue
+		}
+		prev := &merged[n-1]
+		prev.Additional = append(prev.Additional, AdditionalTrack{
+			Artist: t.MainArtist, Title: t.MainTitle, Label: t.MainLabel, Credits: t.Credits,
+		})
+		prev.Additional = append(prev.Additional, t.Additional...)
+		prev.Merged = append(prev.Merged, t.Line)
+		if prev.EndTime == 0 {
+			prev.EndTime = t.EndTime
+		}
+	}
+	return merged
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// span is a track's start and end.
+type span struct{ start, end float64 }
+
+func spans(tracks []Track) []span {
+	out := make([]span, len(tracks))
+	for i, t := range tracks {
+		out[i] = span{t.StartTime, t.EndTime}
+	}
+	return out
+}
+
+func TestCalculateEndTimes(t *testing.T) {
+	gap := EndRules{Gap: 5 * time.Second}
+	tests := []struct {
+		name       string
+		tracks     []Track
+		duration   float64
+		duplicates string
+		want       []span
+	}{
+		{
+			name:     "ends where the next starts",
+			tracks:   []Track{{StartTime: 0}, {StartTime: 100}, {StartTime: 250}},
+			duration: 400,
+			want:     []span{{0, 100}, {100, 250}, {250, 400}},
+		},
+		{
+			name:     "gap, except at the end of the media",
+			tracks:   []Track{{StartTime: 0, Ends: gap}, {StartTime: 100, Ends: gap}},
+			duration: 400,
+			want:     []span{{0, 95}, {100, 400}},
+		},
+		{
+			name:     "gap longer than the track",
+			tracks:   []Track{{StartTime: 0, Ends: EndRules{Gap: time.Minute}}, {StartTime: 30}},
+			duration: 400,
+			want:     []span{{0, 30}, {30, 400}},
+		},
+		{
+			name:     "length cap",
+			tracks:   []Track{{StartTime: 0, Ends: EndRules{MaxLength: time.Minute}}, {StartTime: 100, Ends: EndRules{MaxLength: time.Hour}}},
+			duration: 400,
+			want:     []span{{0, 60}, {100, 400}},
+		},
+		{
+			name:     "set ends are trimmed too",
+			tracks:   []Track{{StartTime: 0, EndTime: 90, Ends: EndRules{MaxLength: time.Minute}}, {StartTime: 100, EndTime: 200, Ends: gap}, {StartTime: 300}},
+			duration: 400,
+			want:     []span{{0, 60}, {100, 195}, {300, 400}},
+		},
+		{
+			name:     "duplicates are merged",
+			tracks:   []Track{{StartTime: 0, Line: 1}, {StartTime: 0, Line: 2}, {StartTime: 100, Line: 3}},
+			duration: 400,
+			want:     []span{{0, 100}, {100, 400}},
+		},
+		{
+			name:       "duplicates kept as errors",
+			tracks:     []Track{{StartTime: 0, Line: 1}, {StartTime: 0, Line: 2}, {StartTime: 100, Line: 3}},
+			duration:   400,
+			duplicates: DuplicatesError,
+			want:       []span{{0, 0}, {0, 100}, {100, 400}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spans(calculateEndTimes(tt.tracks, tt.duration, tt.duplicates))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calculateEndTimes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeDuplicateStarts(t *testing.T) {
+	tracks := []Track{
+		{Line: 1, StartTime: 0, MainArtist: "A", MainTitle: "One"},
+		{Line: 2, StartTime: 0, MainArtist: "B", MainTitle: "Two", MainLabel: "L",
+			Additional: []AdditionalTrack{{Artist: "C", Title: "Three"}}},
+		{Line: 3, StartTime: 0, MainArtist: "D", MainTitle: "Four", EndTime: 80},
+		{Line: 4, StartTime: 100, Segment: "MC", MainTitle: "MC"},
+		{Line: 5, StartTime: 100, MainArtist: "E", MainTitle: "Five"},
+	}
+	got := mergeDuplicateStarts(tracks)
+	if len(got) != 3 {
+		t.Fatalf("mergeDuplicateStarts() left %d tracks, want 3", len(got))
+	}
+	first := got[0]
+	wantAdditional := []AdditionalTrack{
+		{Artist: "B", Title: "Two", Label: "L"},
+		{Artist: "C", Title: "Three"},
+		{Artist: "D", Title: "Four"},
+	}
+	if !reflect.DeepEqual(first.Additional, wantAdditional) {
+		t.Errorf("merged w/ tracks = %+v, want %+v", first.Additional, wantAdditional)
+	}
+	if !reflect.DeepEqual(first.Merged, []int{2, 3}) {
+		t.Errorf("merged lines = %v, want [2 3]", first.Merged)
+	}
+	if first.EndTime != 80 {
+		t.Errorf("merged end = %v, want the merged track's 80", first.EndTime)
+	}
+	if got[1].Line != 4 || got[2].Line != 5 {
+		t.Errorf("segment and the track after it were merged: lines %d and %d", got[1].Line, got[2].Line)
+	}
+}
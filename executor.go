@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command is one run of ffmpeg.
+type Command struct {
+	Args []string
+	// Stdout and Stderr receive the process's output; nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Progress, if set, is called as ffmpeg reports how much of the output
+	// it has written. Backends may ignore it.
+	Progress func(done time.Duration)
+}
+
+// Executor runs ffmpeg and ffprobe. The default, execExecutor, runs the
+// binaries on this machine; tests and other backends, such as a remote
+// worker, replace the executor variable.
+type Executor interface {
+	// Run runs ffmpeg to completion. A failure of the process itself is
+	// returned as is, so callers can inspect an *exec.ExitError.
+	Run(ctx context.Context, c *Command) error
+	// Probe runs ffprobe and returns its standard output.
+	Probe(ctx context.Context, args ...string) ([]byte, error)
+}
+
+var executor Executor = execExecutor{}
+
+// cancelGrace is how long a cancelled process has to exit before it is
+// killed.
+const cancelGrace = 10 * time.Second
+
+// execExecutor runs the ffmpeg and ffprobe found in PATH, ffmpeg at the
+// priority and memory limit of the scheduler.
+type execExecutor struct{}
+
+func (execExecutor) Run(ctx context.Context, c *Command) error {
+	args := c.Args
+	var progress *progressWriter
+	if c.Progress != nil && c.Stdout == nil {
+		// ffmpeg writes key=value progress blocks to stdout when it is
+		// otherwise unused.
+		progress = &progressWriter{fn: c.Progress}
+		args = append([]string{"-progress", "pipe:1"}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	setCancel(cmd)
+	setPriority(cmd, procs.Priority)
+	if procs.MemoryLimit > 0 {
+		limitMemory(cmd, int64(procs.MemoryLimit))
+	}
+	cmd.Stdout, cmd.Stderr = c.Stdout, c.Stderr
+	if progress != nil {
+		cmd.Stdout = progress
+	}
+	return cmd.Run()
+}
+
+func (execExecutor) Probe(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", args...)
+	setCancel(cmd)
+	return cmd.Output()
+}
+
+// progressWriter reads the out_time_us lines of ffmpeg's -progress output.
+type progressWriter struct {
+	fn   func(time.Duration)
+	line []byte
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.line = append(p.line, b...)
+	for {
+		i := bytes.IndexByte(p.line, '\n')
+		if i < 0 {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(string(p.line[:i])), "=")
+		p.line = p.line[i+1:]
+		if key != "out_time_us" {
+			continue
+		}
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.fn(time.Duration(us) * time.Microsecond)
+		}
+	}
+	return len(b), nil
+}
+
+// ffmpegOutput runs ffmpeg through the scheduler and returns its combined
+// output, like exec.Cmd.CombinedOutput.
+func ffmpegOutput(ctx context.Context, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	err := runFFmpeg(ctx, &Command{Args: args, Stdout: &out, Stderr: &out})
+	return out.Bytes(), err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeExecutor stands in for ffmpeg and ffprobe: each run is answered by the
+// first rule whose args contain all of its match, and every ffmpeg command
+// is recorded.
+type fakeExecutor struct {
+	mu   sync.Mutex
+	runs [][]string
+
+	ffmpeg  []fakeRun
+	ffprobe []fakeProbe
+}
+
+// fakeRun answers an ffmpeg run with stderr, progress reports and err.
+type fakeRun struct {
+	match    []string
+	stderr   string
+	progress []time.Duration
+	err      error
+}
+
+// fakeProbe answers an ffprobe run with output and err.
+type fakeProbe struct {
+	match  []string
+	output string
+	err    error
+}
+
+// useExecutor installs f as the executor for the rest of the test.
+func useExecutor(t *testing.T, f *fakeExecutor) *fakeExecutor {
+	t.Helper()
+	prev := executor
+	executor = f
+	t.Cleanup(func() { executor = prev })
+	return f
+}
+
+func matches(args, match []string) bool {
+	for _, m := range match {
+		if !slices.Contains(args, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *fakeExecutor) Run(ctx context.Context, c *Command) error {
+	f.mu.Lock()
+	f.runs = append(f.runs, c.Args)
+	f.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, r := range f.ffmpeg {
+		if !matches(c.Args, r.match) {
+			continue
+		}
+		if c.Stderr != nil {
+			io.WriteString(c.Stderr, r.stderr)
+		}
+		if c.Progress != nil {
+			for _, done := range r.progress {
+				c.Progress(done)
+			}
+		}
+		return r.err
+	}
+	return nil
+}
+
+func (f *fakeExecutor) Probe(ctx context.Context, args ...string) ([]byte, error) {
+	for _, p := range f.ffprobe {
+		if matches(args, p.match) {
+			return []byte(p.output), p.err
+		}
+	}
+	return nil, nil
+}
+
+// ran reports how many ffmpeg runs had args containing all of match.
+func (f *fakeExecutor) ran(match ...string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, args := range f.runs {
+		if matches(args, match) {
+			n++
+		}
+	}
+	return n
+}
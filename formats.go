@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+	FormatOpus = "opus"
+	FormatMP4  = "mp4"
+	FormatWebM = "webm"
+)
+
+var (
+	audioFormats = []string{FormatMP3, FormatFLAC, FormatOpus}
+	videoFormats = []string{FormatMP4, FormatWebM}
+	// opusRates are the sample rates libopus can encode at.
+	opusRates = []string{"8000", "12000", "16000", "24000", "48000"}
+)
+
+// formats splits --format into the audio formats, defaulting to mp3, and
+// the video format, defaulting to mp4. It assumes validateFormats passed.
+func (o Options) formats() (audio []string, video string) {
+	video = FormatMP4
+	for _, f := range strings.Split(o.Format, ",") {
+		switch f = strings.TrimSpace(f); {
+		case slices.Contains(audioFormats, f):
+			audio = append(audio, f)
+		case slices.Contains(videoFormats, f):
+			video = f
+		}
+	}
+	if audio == nil {
+		audio = []string{FormatMP3}
+	}
+	return audio, video
+}
+
+// validateFormats checks --format against --audio and --video.
+func validateFormats(opts Options) error {
+	if opts.Format == "" {
+		return nil
+	}
+	var audio, video []string
+	for _, f := range strings.Split(opts.Format, ",") {
+		switch f = strings.TrimSpace(f); {
+		case slices.Contains(audioFormats, f):
+			if slices.Contains(audio, f) {
+				return fmt.Errorf("format %s is listed twice", f)
+			}
+			audio = append(audio, f)
+		case slices.Contains(videoFormats, f):
+			video = append(video, f)
+		default:
+			return fmt.Errorf("invalid format %q: want %s for audio or %s for video",
+				f, strings.Join(audioFormats, ", "), strings.Join(videoFormats, " or "))
+		}
+	}
+	switch {
+	case len(audio) > 0 && !opts.Audio:
+		return fmt.Errorf("format %s requires --audio", audio[0])
+	case len(video) > 0 && !opts.Video:
+		return fmt.Errorf("format %s requires --video", video[0])
+	case len(video) > 1:
+		return fmt.Errorf("only one video format can be given")
+	}
+
+	opus := slices.Contains(audio, FormatOpus) || slices.Contains(video, FormatWebM)
+	if opus && opts.SampleRate != "" && opts.SampleRate != SampleRateKeep && !slices.Contains(opusRates, opts.SampleRate) {
+		return fmt.Errorf("sample rate %s is not supported by Opus: want 8000, 12000, 16000, 24000 or 48000", opts.SampleRate)
+	}
+	return nil
+}
+
+// audioFormat is the format of an audio job's outputs.
+func (j *Job) audioFormat() string {
+	audio, _ := j.formats()
+	return audio[0]
+}
+
+// canEmbedArtwork reports whether the job's container can hold a cover.
+// Neither WebM nor Ogg allow attached pictures.
+func (j *Job) canEmbedArtwork() bool {
+	if j.Audio {
+		return j.audioFormat() != FormatOpus
+	}
+	return !j.webm()
+}
+
+// audioCodecArgs encode the audio formats other than MP3. FLAC is lossless
+// either way; Opus previews use a lower bitrate.
+func audioCodecArgs(job *Job, preview bool) []string {
+	if job.audioFormat() == FormatFLAC {
+		return []string{"-c:a", "flac", "-compression_level", "8"}
+	}
+	return []string{"-c:a", "libopus", "-b:a", opusBitrate(job, preview)}
+}
+
+// finishSiblingTrack completes a track whose output was written by the
+// job's encodedBy job.
+func finishSiblingTrack(t *Track, job *Job) error {
+	if !fileExists(t.OutputFilename) {
+		return fmt.Errorf("not written, as the %s encode failed", job.encodedBy.audioFormat())
+	}
+	if job.lrcLyrics() && job.Preview == 0 {
+		if err := writeLRC(t); err != nil {
+			return fmt.Errorf("write lyrics: %v", err)
+		}
+	}
+	return nil
+}
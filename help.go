@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// flagGroups sorts the split flags into sections of the --help output. Flags
+// not listed here are shown under "Other".
+var flagGroups = []struct {
+	title string
+	flags []string
+}{
+	{"Input and output", []string{"tracklist", "input", "audio-stream", "video-stream", "audio", "video", "batch", "download", "overrides",
+		"recording-start", "measure-duration", "align", "align-window", "detect-boundaries", "confirm-estimates", "format", "video-codec", "video-copy", "channels", "keep-channels",
+		"sample-rate", "preview", "crop", "rotate", "scale", "title-overlay", "overlay-fade", "overlay-font", "overlay-position", "segments", "duplicates",
+		"max-track-length", "track-gap", "detect-silence", "confirm", "resume"}},
+	{"Tags", []string{"album", "date", "year", "album-artist", "genre", "tag", "disc", "compilation",
+		"strip-version", "id3-version", "id3-encoding", "id3v1", "lyrics", "lyrics-cache"}},
+	{"Analysis and extras", []string{"analyze", "analyze-tags", "verify", "waveforms", "spectrograms",
+		"thumbnails", "stems", "nml", "checksums", "archive", "archive-only", "upload"}},
+	{"Filenames", []string{"filenames", "filename-replacement", "filename-max-length"}},
+	{"Processes", []string{"fail-fast", "priority", "max-load", "memory-limit"}},
+	{"Hooks and notifications", []string{"pre-track-hook", "post-track-hook", "post-run-hook",
+		"notify-url", "notify-desktop"}},
+}
+
+const helpExamples = `Examples:
+  Split a recording into MP3s:
+    song-splitter --tracklist set.txt --input set.mp4 --audio
+
+  Write FLAC and MP4 outputs from a URL, measuring loudness:
+    song-splitter --tracklist set.txt --input https://example.com/set.mp4 --audio --video --format flac,mp4 --analyze
+
+  Check a tracklist against its recording first:
+    song-splitter validate --tracklist set.txt --input set.mp4
+
+  Install bash completion:
+    song-splitter completion bash > /etc/bash_completion.d/song-splitter
+`
+
+// printUsage is the --help output of the split command: the subcommands,
+// the flags by section, and examples.
+func printUsage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "Usage: song-splitter [flags]")
+	fmt.Fprintln(w, "       song-splitter <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, name := range subcommandNames() {
+		fmt.Fprintf(w, "  %-12s %s\n", name, subcommands[name].summary)
+	}
+
+	grouped := make(map[string]bool)
+	for _, g := range flagGroups {
+		fmt.Fprintf(w, "\n%s:\n", g.title)
+		for _, name := range g.flags {
+			if f := flag.Lookup(name); f != nil {
+				printFlag(w, f)
+				grouped[name] = true
+			}
+		}
+	}
+	var other []*flag.Flag
+	flag.VisitAll(func(f *flag.Flag) {
+		if !grouped[f.Name] {
+			other = append(other, f)
+		}
+	})
+	if len(other) > 0 {
+		fmt.Fprintln(w, "\nOther:")
+		for _, f := range other {
+			printFlag(w, f)
+		}
+	}
+
+	fmt.Fprintln(w, "\nRun song-splitter <command> -h for a command's flags.")
+	fmt.Fprintln(w)
+	fmt.Fprint(w, helpExamples)
+}
+
+// printFlag writes one flag the way flag.PrintDefaults does.
+func printFlag(w io.Writer, f *flag.Flag) {
+	name, usage := flag.UnquoteUsage(f)
+	line := "  --" + f.Name
+	if name != "" {
+		line += " " + name
+	}
+	usage = strings.ReplaceAll(usage, "\n", "\n    \t")
+	fmt.Fprintf(w, "%s\n    \t%s", line, usage)
+	switch f.DefValue {
+	case "", "0", "0s", "false":
+	default:
+		if name == "string" {
+			fmt.Fprintf(w, " (default %q)", f.DefValue)
+		} else {
+			fmt.Fprintf(w, " (default %v)", f.DefValue)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func subcommandNames() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
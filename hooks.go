@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// runHook runs a user-supplied command through the platform shell with the
+// given SPLITTER_* variables added to the environment.
+func runHook(ctx context.Context, command string, env []string) error {
+	cmd := shellCommand(ctx, command, env)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%q: %v", command, err)
+	}
+	return nil
+}
+
+// shellCommand prepares command to run through the platform shell with env
+// added to the environment.
+func shellCommand(ctx context.Context, command string, env []string) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	setCancel(cmd)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+func (j *Job) runTrackHook(ctx context.Context, command string, i int, status TrackStatus, trackErr error) error {
+	if command == "" {
+		return nil
+	}
+
+	return runHook(ctx, command, j.trackEnv(i, status, trackErr))
+}
+
+// trackEnv describes track i to a command run for it.
+func (j *Job) trackEnv(i int, status TrackStatus, trackErr error) []string {
+	t := &j.Tracks[i]
+	env := append(j.baseEnv(),
+		"SPLITTER_TRACK_INDEX="+strconv.Itoa(i+1),
+		"SPLITTER_TRACK_PATH="+t.OutputFilename,
+		"SPLITTER_TRACK_ARTIST="+t.MainArtist,
+		"SPLITTER_TRACK_TITLE="+buildTitle(t),
+		"SPLITTER_TRACK_LABEL="+t.MainLabel,
+		"SPLITTER_TRACK_START="+strconv.FormatFloat(t.StartTime, 'f', 3, 64),
+		"SPLITTER_TRACK_END="+strconv.FormatFloat(t.EndTime, 'f', 3, 64),
+		"SPLITTER_TRACK_STATUS="+string(status),
+	)
+	if trackErr != nil {
+		// Only the first line; ffmpeg output follows on later lines.
+		msg, _, _ := strings.Cut(trackErr.Error(), "\n")
+		env = append(env, "SPLITTER_TRACK_ERROR="+msg)
+	}
+	return env
+}
+
+func (j *Job) runEnv(failed int) []string {
+	return append(j.baseEnv(),
+		"SPLITTER_FAILED="+strconv.Itoa(failed),
+		"SPLITTER_SUCCEEDED="+strconv.Itoa(len(j.Tracks)-failed),
+	)
+}
+
+func (j *Job) baseEnv() []string {
+	return []string{
+		"SPLITTER_ALBUM=" + j.Album,
+		"SPLITTER_INPUT=" + j.Input,
+		"SPLITTER_OUTPUT_DIR=" + j.OutputDir,
+		"SPLITTER_TRACK_TOTAL=" + strconv.Itoa(len(j.Tracks)),
+	}
+}
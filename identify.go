@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	acoustIDLookup = "https://api.acoustid.org/v2/lookup"
+	// acoustIDInterval keeps lookups under AcoustID's three requests a
+	// second.
+	acoustIDInterval = 350 * time.Millisecond
+	// identifyGap is the shortest unidentified stretch, in seconds, that is
+	// written as an "ID - ID" track of its own rather than left to the
+	// track before.
+	identifyGap = 90
+)
+
+// identifier names the music in a short clip of the mix.
+type identifier interface {
+	identify(ctx context.Context, clip string, length float64) (*identMatch, error)
+}
+
+// identMatch is what an identifier found in a clip; a nil match means
+// nothing was recognised.
+type identMatch struct {
+	Artist string
+	Title  string
+	// Score is the service's confidence, from 0 to 1.
+	Score float64
+}
+
+// identWindow is one clip of the mix and its best match.
+type identWindow struct {
+	Start float64
+	Match *identMatch
+}
+
+// identRun is a stretch of consecutive windows matching the same track.
+type identRun struct {
+	Start, End float64
+	Match      identMatch
+	Windows    int
+}
+
+func identifyCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	input := flags.String("input", "", "The mix to identify: a file or URL")
+	outDir := flags.String("out", ".", "Directory for the proposed tracklist and review file")
+	window := flags.Duration("window", 20*time.Second, "Length of each clip fingerprinted")
+	step := flags.Duration("step", 30*time.Second, "Time between the starts of clips; shorter finds boundaries more precisely but takes longer")
+	minScore := flags.Float64("min-score", 0.5, "Minimum score (0-1) for a match to count")
+	apiKey := flags.String("api-key", os.Getenv("ACOUSTID_API_KEY"), "AcoustID application API key (default $ACOUSTID_API_KEY)")
+	fpcalc := flags.String("fpcalc", "fpcalc", "Chromaprint fpcalc executable used to fingerprint clips for AcoustID")
+	command := flags.String("command", "", "Instead of AcoustID, shell command identifying the clip at $SPLITTER_CLIP_PATH by printing \"Artist - Title\", or nothing if it is unknown")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: song-splitter identify --input <mix> [flags]")
+		flags.PrintDefaults()
+	}
+	return func(logger *slog.Logger) error {
+		if *input == "" {
+			return errors.New("--input is required")
+		}
+		if *window <= 0 || *step <= 0 {
+			return errors.New("--window and --step must be positive")
+		}
+		var id identifier
+		switch {
+		case *command != "":
+			id = commandIdentifier{command: *command}
+		case *apiKey != "":
+			id = &acoustID{key: *apiKey, fpcalc: *fpcalc, http: &http.Client{Timeout: 30 * time.Second}}
+		default:
+			return errors.New("--api-key, $ACOUSTID_API_KEY or --command is required")
+		}
+
+		ctx := context.Background()
+		duration, err := getMediaDuration(*input)
+		if err != nil {
+			return err
+		}
+		windows, err := scanWindows(ctx, logger, id, *input, duration, window.Seconds(), step.Seconds(), *minScore)
+		if err != nil {
+			return err
+		}
+		runs := clusterWindows(windows, step.Seconds())
+		tracks := proposeTracks(runs, duration)
+
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+		header := strings.TrimSuffix(filepath.Base(*input), filepath.Ext(*input))
+		if isURL(*input) {
+			header = *input
+		}
+		tracklistOut := filepath.Join(*outDir, "tracklist.txt")
+		if err := writeFile(tracklistOut, func(f *os.File) error { return writeTracklist(f, header, tracks) }); err != nil {
+			return err
+		}
+		reviewOut := filepath.Join(*outDir, "review.txt")
+		if err := writeFile(reviewOut, func(f *os.File) error { return writeIdentifyReview(f, windows, runs) }); err != nil {
+			return err
+		}
+		logger.Info("Wrote tracklist", "path", tracklistOut, "tracks", len(tracks), "identified", len(runs), "review", reviewOut)
+		fmt.Printf("Review %s and fill in any \"ID - ID\" tracks, then run:\n  song-splitter --input %q --tracklist %q --audio\n", reviewOut, *input, tracklistOut)
+		return nil
+	}
+}
+
+// scanWindows cuts a clip every step seconds and identifies it, keeping
+// matches scoring at least minScore.
+func scanWindows(ctx context.Context, logger *slog.Logger, id identifier, input string, duration, window, step, minScore float64) ([]identWindow, error) {
+	dir, err := os.MkdirTemp("", "song-splitter-identify-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	clip := filepath.Join(dir, "clip.wav")
+
+	var windows []identWindow
+	for start := 0.0; start < duration; start += step {
+		length := min(window, duration-start)
+		if length < window/2 && start > 0 {
+			// Too little of the end is left to identify.
+			break
+		}
+		args := []string{"-v", "error", "-ss", fmt.Sprintf("%f", start)}
+		args = append(args, inputArgs(input)...)
+		args = append(args, "-t", fmt.Sprintf("%f", length), "-map", "0:a:0", "-ac", "2", "-ar", "44100", "-y", clip)
+		if output, err := ffmpegOutput(ctx, args...); err != nil {
+			return nil, fmt.Errorf("ffmpeg error: %v\n%s", err, string(output))
+		}
+
+		m, err := id.identify(ctx, clip, length)
+		if err != nil {
+			return nil, fmt.Errorf("identify %s: %v", formatTimestamp(start), err)
+		}
+		if m != nil && m.Score < minScore {
+			m = nil
+		}
+		if m != nil {
+			logger.Info("Identified", "at", formatTimestamp(start), "artist", m.Artist, "title", m.Title, "score", m.Score)
+		}
+		windows = append(windows, identWindow{Start: start, Match: m})
+	}
+	return windows, nil
+}
+
+// clusterWindows joins consecutive windows matching the same track into
+// runs, bridging a window that matched nothing. A run of a single window is
+// as likely a misidentified transition as a track, so it is dropped, and the
+// runs either side of it joined if they are the same track.
+func clusterWindows(windows []identWindow, step float64) []identRun {
+	var runs []identRun
+	for _, w := range windows {
+		if w.Match != nil {
+			runs = appendRun(runs, identRun{Start: w.Start, End: w.Start + step, Match: *w.Match, Windows: 1}, step)
+		}
+	}
+	var kept []identRun
+	for _, r := range runs {
+		if r.Windows > 1 || len(runs) == 1 {
+			kept = appendRun(kept, r, 2*step)
+		}
+	}
+	return kept
+}
+
+// appendRun adds r to runs, joining it to the last run if that is the same
+// track and ends no more than gap seconds before r starts.
+func appendRun(runs []identRun, r identRun, gap float64) []identRun {
+	if n := len(runs); n > 0 {
+		last := &runs[n-1]
+		if last.Match.key() == r.Match.key() && r.Start-last.End <= gap {
+			last.End = r.End
+			last.Windows += r.Windows
+			last.Match.Score = max(last.Match.Score, r.Match.Score)
+			return runs
+		}
+	}
+	return append(runs, r)
+}
+
+func (m identMatch) key() string {
+	return normalizeTrackKey(m.Artist + " " + m.Title)
+}
+
+// proposeTracks turns runs into a tracklist, each track starting with the
+// first window it was found in. Stretches of at least identifyGap where
+// nothing was found become "ID - ID" tracks to fill in by hand.
+func proposeTracks(runs []identRun, duration float64) []Track {
+	var tracks []Track
+	end := 0.0
+	for _, r := range runs {
+		if r.Start-end >= identifyGap {
+			tracks = append(tracks, Track{StartTime: end, MainArtist: "ID", MainTitle: "ID"})
+		}
+		tracks = append(tracks, Track{StartTime: r.Start, MainArtist: r.Match.Artist, MainTitle: r.Match.Title})
+		end = r.End
+	}
+	if duration-end >= identifyGap {
+		tracks = append(tracks, Track{StartTime: end, MainArtist: "ID", MainTitle: "ID"})
+	}
+	return tracks
+}
+
+func writeIdentifyReview(f *os.File, windows []identWindow, runs []identRun) error {
+	fmt.Fprintln(f, "Tracks:")
+	for _, r := range runs {
+		fmt.Fprintf(f, "[%s-%s] %s - %s (%d windows, best score %.2f)\n",
+			formatTimestamp(r.Start), formatTimestamp(r.End), r.Match.Artist, r.Match.Title, r.Windows, r.Match.Score)
+	}
+	fmt.Fprintln(f, "\nWindows:")
+	for _, w := range windows {
+		if w.Match == nil {
+			fmt.Fprintf(f, "[%s] no match\n", formatTimestamp(w.Start))
+			continue
+		}
+		fmt.Fprintf(f, "[%s] %s - %s (score %.2f)\n", formatTimestamp(w.Start), w.Match.Artist, w.Match.Title, w.Match.Score)
+	}
+	return nil
+}
+
+// acoustID fingerprints clips with Chromaprint and looks them up in the
+// AcoustID database.
+type acoustID struct {
+	key    string
+	fpcalc string
+	http   *http.Client
+	last   time.Time
+}
+
+func (a *acoustID) identify(ctx context.Context, clip string, length float64) (*identMatch, error) {
+	cmd := exec.CommandContext(ctx, a.fpcalc, "-json", "-length", strconv.Itoa(int(length)+1), clip)
+	setCancel(cmd)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s error: %v", a.fpcalc, err)
+	}
+	var fp struct {
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(output, &fp); err != nil {
+		return nil, fmt.Errorf("%s output: %v", a.fpcalc, err)
+	}
+
+	if wait := acoustIDInterval - time.Since(a.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	a.last = time.Now()
+	form := url.Values{
+		"client":      {a.key},
+		"meta":        {"recordings"},
+		"duration":    {strconv.Itoa(int(fp.Duration))},
+		"fingerprint": {fp.Fingerprint},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, acoustIDLookup, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Status string `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Results []struct {
+			Score      float64 `json:"score"`
+			Recordings []struct {
+				Title   string `json:"title"`
+				Artists []struct {
+					Name       string `json:"name"`
+					JoinPhrase string `json:"joinphrase"`
+				} `json:"artists"`
+			} `json:"recordings"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("AcoustID: %s", resp.Status)
+	}
+	if result.Status != "ok" {
+		return nil, fmt.Errorf("AcoustID: %s", result.Error.Message)
+	}
+
+	// Results come best first; take the first with a named recording.
+	for _, r := range result.Results {
+		for _, rec := range r.Recordings {
+			if rec.Title == "" || len(rec.Artists) == 0 {
+				continue
+			}
+			var artist strings.Builder
+			for _, a := range rec.Artists {
+				artist.WriteString(a.Name + a.JoinPhrase)
+			}
+			return &identMatch{Artist: artist.String(), Title: rec.Title, Score: r.Score}, nil
+		}
+	}
+	return nil, nil
+}
+
+// commandIdentifier asks a user-supplied command, such as a wrapper around
+// a Shazam client, what is playing in a clip.
+type commandIdentifier struct {
+	command string
+}
+
+func (c commandIdentifier) identify(ctx context.Context, clip string, length float64) (*identMatch, error) {
+	cmd := shellCommand(ctx, c.command, []string{
+		"SPLITTER_CLIP_PATH=" + clip,
+		"SPLITTER_CLIP_LENGTH=" + strconv.FormatFloat(length, 'f', 3, 64),
+	})
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", c.command, err)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if line == "" {
+		return nil, nil
+	}
+	artist, title, _, err := parseArtistTitle(strings.TrimSpace(line))
+	if err != nil {
+		return nil, fmt.Errorf("%q printed %q, not \"Artist - Title\"", c.command, line)
+	}
+	return &identMatch{Artist: artist, Title: title, Score: 1}, nil
+}
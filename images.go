@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	WaveformsTracks = "tracks"
+	WaveformsSet    = "set"
+	WaveformsBoth   = "both"
+)
+
+const (
+	trackWaveformSize = "1600x240"
+	spectrogramSize   = "1600x800"
+	setWaveformWidth  = 4000
+	setWaveformHeight = 400
+)
+
+// sidecarSuffixes are the files that may be written next to a track's
+// output, sharing its name.
+var sidecarSuffixes = []string{".lrc", ".jpg", ".waveform.png", ".spectrogram.png"}
+
+// thumbnailOffset is how far into a track its thumbnail is taken, past any
+// transition from the previous track.
+const thumbnailOffset = 5.0
+
+// sidecarPath is the track's output path with its extension replaced by
+// suffix.
+func sidecarPath(t *Track, suffix string) string {
+	return strings.TrimSuffix(t.OutputFilename, filepath.Ext(t.OutputFilename)) + suffix
+}
+
+// trackSidecars lists the sidecar files that exist for t.
+func trackSidecars(t *Track) []string {
+	var files []string
+	for _, suffix := range sidecarSuffixes {
+		if p := sidecarPath(t, suffix); fileExists(p) {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func validWaveformMode(mode string) bool {
+	switch mode {
+	case "", WaveformsTracks, WaveformsSet, WaveformsBoth:
+		return true
+	}
+	return false
+}
+
+func (j *Job) trackWaveforms() bool {
+	return j.Waveforms == WaveformsTracks || j.Waveforms == WaveformsBoth
+}
+
+func (j *Job) setWaveform() bool {
+	return j.Waveforms == WaveformsSet || j.Waveforms == WaveformsBoth
+}
+
+// renderTrackWaveform draws the waveform of a finished output.
+func renderTrackWaveform(ctx context.Context, t *Track) error {
+	return renderImage(ctx, inputArgs(t.OutputFilename),
+		"[0:a:0]showwavespic=s="+trackWaveformSize+":colors=0x3a7bd5", sidecarPath(t, ".waveform.png"))
+}
+
+// renderSpectrogram draws a track's spectrogram with a frequency legend, so
+// a low-pass cutoff left by a lossy transcode shows up as a flat ceiling.
+func renderSpectrogram(ctx context.Context, t *Track) error {
+	return renderImage(ctx, inputArgs(t.OutputFilename),
+		"[0:a:0]showspectrumpic=s="+spectrogramSize+":legend=1:scale=log", sidecarPath(t, ".spectrogram.png"))
+}
+
+// renderSetWaveform draws the whole recording with a red line at every cut
+// point, to check whether cuts land in the right place. It writes
+// waveform.png to the output directory.
+func renderSetWaveform(ctx context.Context, job *Job) (string, error) {
+	filter := fmt.Sprintf("[%s]aformat=channel_layouts=mono,showwavespic=s=%dx%d:colors=0x3a7bd5",
+		job.audioMap(), setWaveformWidth, setWaveformHeight)
+	for _, t := range job.Tracks[1:] {
+		x := int(t.StartTime / job.Duration * setWaveformWidth)
+		filter += fmt.Sprintf(",drawbox=x=%d:y=0:w=2:h=ih:color=red:t=fill", x)
+	}
+	path := filepath.Join(job.OutputDir, "waveform.png")
+	return path, renderImage(ctx, inputArgs(job.Input), filter, path)
+}
+
+// renderThumbnail saves a frame from a few seconds into the track, taken
+// from the source so it is ready to embed as the output's cover.
+func renderThumbnail(ctx context.Context, job *Job, t *Track) error {
+	at := t.StartTime + min(thumbnailOffset, (t.EndTime-t.StartTime)/2)
+	args := []string{"-v", "error", "-ss", fmt.Sprintf("%f", at)}
+	args = append(args, inputArgs(job.Input)...)
+	args = append(args, "-map", job.videoMap())
+	if filters := t.Transform.filters(""); len(filters) > 0 {
+		// Show the frame as the track's video does.
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args, "-frames:v", "1", "-q:v", "2", "-y", sidecarPath(t, ".jpg"))
+	if output, err := ffmpegOutput(ctx, args...); err != nil {
+		return fmt.Errorf("ffmpeg error: %v\n%s", err, string(output))
+	}
+	return nil
+}
+
+// renderImage runs a filter graph producing a single picture.
+func renderImage(ctx context.Context, inArgs []string, filter, out string) error {
+	args := append([]string{"-v", "error"}, inArgs...)
+	args = append(args, "-filter_complex", filter, "-frames:v", "1", "-y", out)
+	if output, err := ffmpegOutput(ctx, args...); err != nil {
+		return fmt.Errorf("ffmpeg error: %v\n%s", err, string(output))
+	}
+	return nil
+}
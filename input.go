@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// stdinInput is the --input value that reads the media from standard input.
+const stdinInput = "-"
+
+// bufferStdin copies standard input into a temporary file and returns its
+// path. Every track seeks into the input independently, so a pipe cannot be
+// handed to ffmpeg directly. The caller removes the file when done.
+func bufferStdin() (string, error) {
+	f, err := os.CreateTemp("", "song-splitter-stdin-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("buffering stdin: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// concatExt is the extension of the playlists joining several --input parts.
+const concatExt = ".ffconcat"
+
+// inputList is the repeatable --input flag.
+type inputList []string
+
+func (l *inputList) String() string { return strings.Join(*l, ", ") }
+
+func (l *inputList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// concatInputs writes an ffconcat playlist playing the parts one after
+// another, as one timeline the tracklist's timestamps run across, and
+// returns its path. Each part's length is listed so that ffmpeg knows where
+// every part starts without reading the ones before. The concat demuxer
+// reads the parts as one stream, so they must have the same streams with
+// the same codecs, as the files of a stream archive do. The caller removes
+// the file when done.
+func concatInputs(parts []string) (string, error) {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	var first []mediaStream
+	for i, part := range parts {
+		if isURL(part) || part == stdinInput {
+			return "", fmt.Errorf("input parts must be local files: %s", part)
+		}
+		abs, err := filepath.Abs(part)
+		if err != nil {
+			return "", err
+		}
+		streams, err := probeStreams(abs)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", part, err)
+		}
+		if i == 0 {
+			first = streams
+		} else if !sameStreams(first, streams) {
+			return "", fmt.Errorf("%s has other streams than %s; every part must be encoded alike", part, parts[0])
+		}
+		duration, err := getMediaDuration(abs)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", part, err)
+		}
+		// Within single quotes only a quote needs escaping, by closing the
+		// quotes around it.
+		fmt.Fprintf(&b, "file '%s'\nduration %f\n", strings.ReplaceAll(abs, "'", `'\''`), duration)
+	}
+
+	f, err := os.CreateTemp("", "song-splitter-input-*"+concatExt)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// sameStreams reports whether two inputs have the same kinds of streams in
+// the same order, with the same codecs and channels.
+func sameStreams(a, b []mediaStream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || a[i].Codec != b[i].Codec || a[i].Channels != b[i].Channels {
+			return false
+		}
+	}
+	return true
+}
+
+func isURL(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// resolvePath resolves p against the directory base unless it already
+// names a place of its own: an absolute path, or on Windows a drive-letter
+// ("C:set.mp4"), UNC ("\\nas\music") or rooted ("\music") path, which
+// filepath.IsAbs alone does not cover.
+func resolvePath(base, p string) string {
+	if filepath.IsAbs(p) || filepath.VolumeName(p) != "" || (p != "" && os.IsPathSeparator(p[0])) {
+		return p
+	}
+	return filepath.Join(base, p)
+}
+
+// inputArgs returns the ffmpeg/ffprobe arguments that open input. Remote
+// inputs get reconnect options so a dropped connection mid-track resumes
+// instead of truncating the output, and playlists of parts are opened with
+// the concat demuxer, allowing it their absolute paths.
+func inputArgs(input string) []string {
+	if strings.EqualFold(filepath.Ext(input), concatExt) {
+		return []string{"-f", "concat", "-safe", "0", "-i", input}
+	}
+	if isURL(input) {
+		return []string{
+			"-reconnect", "1",
+			"-reconnect_streamed", "1",
+			"-reconnect_delay_max", "10",
+			"-i", input,
+		}
+	}
+	return []string{"-i", input}
+}
+
+// downloadInput fetches a remote input into a temporary file, keeping the
+// URL's extension so ffmpeg can still guess the container. Used when many
+// seeks over HTTP would be slower than one sequential download. The caller
+// removes the file when done.
+func downloadInput(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	ext := path.Ext(req.URL.Path)
+	f, err := os.CreateTemp("", "song-splitter-download-*"+ext)
+	if err != nil {
+		return "", err
+	}
+
+	bar := pb.Full.Start64(resp.ContentLength)
+	bar.Set(pb.Bytes, true)
+	_, err = io.Copy(f, bar.NewProxyReader(resp.Body))
+	bar.Finish()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("downloading %s: %v", url, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func loadAverage() (float64, error) {
+	return 0, errors.New("the load average is only read on Linux")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// logsDir is the folder of the output directory holding each track's
+	// ffmpeg log, and the diagnostics of tracks that failed.
+	logsDir = "logs"
+	// diagnosticsTail is how many lines of the log a track's diagnostics
+	// repeat.
+	diagnosticsTail = 50
+	// errorTail is how many lines of ffmpeg's output a track's error
+	// repeats, usually enough to tell what went wrong.
+	errorTail = 3
+)
+
+// trackLogPath is the path in the logs folder named after t's output, with
+// suffix in place of its extension.
+func (j *Job) trackLogPath(t *Track, suffix string) string {
+	base := filepath.Base(t.OutputFilename)
+	return filepath.Join(j.OutputDir, logsDir, strings.TrimSuffix(base, filepath.Ext(base))+suffix)
+}
+
+// runTrackFFmpeg runs c with run on behalf of t, appending its command line,
+// everything it writes to stderr and how it ended to the track's log, which
+// keeps the runs of every attempt. c.Stderr still receives the output. On a
+// failure other than cancellation it also writes the track's diagnostics.
+// The error is run's, as is.
+func (j *Job) runTrackFFmpeg(ctx context.Context, t *Track, run func(context.Context, *Command) error, c *Command) error {
+	logPath := j.trackLogPath(t, ".log")
+	var log io.Writer = io.Discard
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err == nil {
+		if f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			defer f.Close()
+			log = f
+		}
+	}
+
+	// The tail is kept for the diagnostics whether or not the caller
+	// collects the output.
+	var stderr bytes.Buffer
+	fmt.Fprintf(log, "[%s] %s\n", time.Now().Format(time.RFC3339), commandLine(c.Args))
+	writers := []io.Writer{log, &stderr}
+	if c.Stderr != nil {
+		writers = append(writers, c.Stderr)
+	}
+	cmd := *c
+	cmd.Stderr = io.MultiWriter(writers...)
+	err := run(ctx, &cmd)
+	if err != nil {
+		fmt.Fprintf(log, "[%s] failed: %v\n\n", time.Now().Format(time.RFC3339), err)
+		if ctx.Err() == nil {
+			if derr := j.writeDiagnostics(ctx, t, c.Args, err, stderr.Bytes()); derr != nil {
+				fmt.Fprintf(log, "Writing diagnostics failed: %v\n\n", derr)
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(log, "[%s] done\n\n", time.Now().Format(time.RFC3339))
+	return nil
+}
+
+// writeDiagnostics saves what it takes to debug a failed run of t next to
+// its log: the command line, how it ended, ffprobe's view of the input and
+// the end of ffmpeg's output. A later failure of the track replaces it.
+func (j *Job) writeDiagnostics(ctx context.Context, t *Track, args []string, runErr error, stderr []byte) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Track: %02d - %s - %s\n", t.Number, t.MainArtist, t.MainTitle)
+	fmt.Fprintf(&b, "Range: %s to %s\n", formatTimestamp(t.StartTime), formatTimestamp(t.EndTime))
+	fmt.Fprintf(&b, "Failed: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Exit status: %v\n\nCommand:\n%s\n\n", runErr, commandLine(args))
+
+	probe, err := executor.Probe(ctx, append([]string{"-v", "error", "-show_format", "-show_streams", "-of", "json"}, inputArgs(j.Input)...)...)
+	if err != nil {
+		fmt.Fprintf(&b, "Input probe failed: %v\n\n", err)
+	} else {
+		fmt.Fprintf(&b, "Input probe:\n%s\n", bytes.TrimSpace(probe))
+	}
+	fmt.Fprintf(&b, "Last %d lines of output:\n%s\n", diagnosticsTail, tailLines(stderr, diagnosticsTail))
+	return os.WriteFile(j.trackLogPath(t, ".diagnostics.txt"), []byte(b.String()), 0644)
+}
+
+// trackFFmpegError is the error of a failed ffmpeg run of t: how it ended
+// and the last lines ffmpeg wrote, pointing at the diagnostics for the rest
+// rather than including every line. A cancelled run has none.
+func (j *Job) trackFFmpegError(ctx context.Context, t *Track, err error, stderr []byte) error {
+	msg := fmt.Sprintf("ffmpeg error: %v", err)
+	if tail := tailLines(stderr, errorTail); tail != "" {
+		msg += ": " + strings.ReplaceAll(tail, "\n", "; ")
+	}
+	if ctx.Err() != nil {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("%s (see %s)", msg, j.trackLogPath(t, ".diagnostics.txt"))
+}
+
+// tailLines is the last n non-empty lines of output.
+func tailLines(output []byte, n int) string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines[max(len(lines)-n, 0):], "\n")
+}
+
+// commandLine renders an ffmpeg command for pasting into a shell.
+func commandLine(args []string) string {
+	quoted := []string{"ffmpeg"}
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`*?[]{}()<>|&;#~!") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, a)
+	}
+	return strings.Join(quoted, " ")
+}
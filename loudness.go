@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+)
+
+const (
+	// replayGainReference is the loudness ReplayGain 2.0 gains are relative to.
+	replayGainReference = -18.0
+	// silenceFloor stands in for the -inf ebur128 reports for digital
+	// silence, which JSON cannot hold.
+	silenceFloor = -144.0
+)
+
+var (
+	integratedRe = regexp.MustCompile(`I:\s+(-?[\d.]+|-inf) LUFS`)
+	lraRe        = regexp.MustCompile(`LRA:\s+(-?[\d.]+) LU`)
+	truePeakRe   = regexp.MustCompile(`Peak:\s+(-?[\d.]+|-inf) dBFS`)
+)
+
+// Loudness is the EBU R128 measurement of one track.
+type Loudness struct {
+	// Integrated is the programme loudness in LUFS.
+	Integrated float64 `json:"integrated_lufs"`
+	// TruePeak is in dBTP.
+	TruePeak float64 `json:"true_peak_dbtp"`
+	// Range is the loudness range (LRA) in LU, a measure of dynamics.
+	Range float64 `json:"range_lu"`
+}
+
+// analyzeTrack measures the whole track in the source, so nothing has to be
+// encoded first.
+func analyzeTrack(ctx context.Context, t *Track, job *Job) (*Loudness, error) {
+	coarse, fine := seekArgs(t.StartTime)
+	args := append([]string{"-hide_banner", "-nostats"}, coarse...)
+	args = append(args, inputArgs(job.Input)...)
+	args = append(args, fine...)
+	args = append(args, "-t", fmt.Sprintf("%f", t.EndTime-t.StartTime),
+		// framelog=verbose keeps the per-frame lines out of the output,
+		// leaving the summary.
+		"-map", job.audioMap(), "-af", "ebur128=peak=true:framelog=verbose", "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	if err := job.runTrackFFmpeg(ctx, t, runFFmpeg, &Command{Args: args, Stderr: &stderr}); err != nil {
+		return nil, job.trackFFmpegError(ctx, t, err, stderr.Bytes())
+	}
+
+	out := stderr.String()
+	l := &Loudness{}
+	for _, m := range []struct {
+		re  *regexp.Regexp
+		dst *float64
+	}{{integratedRe, &l.Integrated}, {truePeakRe, &l.TruePeak}, {lraRe, &l.Range}} {
+		// The summary comes last, after any per-frame lines.
+		all := m.re.FindAllStringSubmatch(out, -1)
+		if all == nil {
+			return nil, fmt.Errorf("no loudness summary in ffmpeg output")
+		}
+		v := all[len(all)-1][1]
+		if v == "-inf" {
+			*m.dst = silenceFloor
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		*m.dst = f
+	}
+	return l, nil
+}
+
+// replayGainTags are REPLAYGAIN_* tags derived from a measurement, which
+// players use to level tracks at playback time. gain is the adjustment the
+// output was encoded with, since the measurement is of the source.
+func replayGainTags(l *Loudness, gain float64) []string {
+	return []string{
+		"-metadata", fmt.Sprintf("REPLAYGAIN_TRACK_GAIN=%+.2f dB", replayGainReference-(l.Integrated+gain)),
+		"-metadata", fmt.Sprintf("REPLAYGAIN_TRACK_PEAK=%.6f", math.Pow(10, (l.TruePeak+gain)/20)),
+		"-metadata", fmt.Sprintf("REPLAYGAIN_REFERENCE_LOUDNESS=%.1f LUFS", replayGainReference),
+	}
+}
+
+func (j *Job) setLoudness(i int, l *Loudness) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.loudness == nil {
+		j.loudness = make([]*Loudness, len(j.Tracks))
+	}
+	j.loudness[i] = l
+}
+
+// Loudness returns track i's measurement, or nil without --analyze.
+func (j *Job) Loudness(i int) *Loudness {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if i < len(j.loudness) {
+		return j.loudness[i]
+	}
+	return nil
+}
+
+// writeLoudnessSummary prints a table of every measured track.
+func writeLoudnessSummary(w io.Writer, job *Job) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "#\tLUFS\tdBTP\tLRA\t\tTrack")
+	for i := range job.Tracks {
+		t := &job.Tracks[i]
+		l := job.Loudness(i)
+		if l == nil {
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%.1f\t%.1f\t%.1f\t\t%s - %s\n", i+1, l.Integrated, l.TruePeak, l.Range, t.MainArtist, buildTitle(t))
+	}
+	tw.Flush()
+}
+
+// writeReport saves the job's report as report.json in its output directory.
+func writeReport(job *Job) (string, error) {
+	data, err := json.MarshalIndent(job.Report(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(job.OutputDir, "report.json")
+	return path, os.WriteFile(path, data, 0644)
+}
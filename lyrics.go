@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/milindmadhukar/song-splitter/lyrics"
+)
+
+const (
+	LyricsEmbed = "embed"
+	LyricsLRC   = "lrc"
+	LyricsBoth  = "both"
+)
+
+// lyricsMissFile lists the tracks no lyrics were found for.
+const lyricsMissFile = "lyrics-misses.txt"
+
+func validLyricsMode(mode string) bool {
+	switch mode {
+	case "", LyricsEmbed, LyricsLRC, LyricsBoth:
+		return true
+	}
+	return false
+}
+
+func (j *Job) embedLyrics() bool { return j.Lyrics == LyricsEmbed || j.Lyrics == LyricsBoth }
+func (j *Job) lrcLyrics() bool   { return j.Lyrics == LyricsLRC || j.Lyrics == LyricsBoth }
+
+// fetchLyrics looks up every track's lyrics before encoding so they can be
+// embedded, and writes the miss report into the output directory. Lookup
+// errors are logged and treated as misses; lyrics never fail a run.
+func fetchLyrics(ctx context.Context, job *Job, logger *slog.Logger) {
+	cacheDir := job.LyricsCache
+	if cacheDir == "" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(dir, "song-splitter", "lyrics")
+		}
+	}
+	client := &lyrics.Client{CacheDir: cacheDir, UserAgent: "song-splitter (https://github.com/milindmadhukar/song-splitter)"}
+
+	var misses []string
+	for i := range job.Tracks {
+		t := &job.Tracks[i]
+		artist, title := t.MainArtist, t.Credits.BaseTitle
+		if len(t.Credits.Artists) > 0 {
+			artist = t.Credits.Artists[0]
+		}
+		if strings.EqualFold(artist, "ID") || strings.EqualFold(title, "ID") {
+			continue
+		}
+
+		lyr, err := client.Lookup(ctx, artist, title)
+		if err != nil {
+			logger.Warn("Lyrics lookup failed", "track", t.MainTitle, "error", err)
+		}
+		if lyr == nil || (lyr.Plain == "" && lyr.Synced == "") {
+			misses = append(misses, fmt.Sprintf("%02d - %s - %s", t.Number, t.MainArtist, t.MainTitle))
+			continue
+		}
+		t.Lyrics = lyr.Plain
+		t.SyncedLyrics = lyr.Synced
+	}
+
+	logger.Info("Fetched lyrics", "found", len(job.Tracks)-len(misses), "missing", len(misses))
+	if len(misses) == 0 {
+		return
+	}
+	report := filepath.Join(job.OutputDir, lyricsMissFile)
+	if err := os.WriteFile(report, []byte(strings.Join(misses, "\n")+"\n"), 0644); err != nil {
+		logger.Warn("Writing lyrics miss report failed", "error", err)
+	}
+}
+
+// writeLRC saves synced lyrics next to the track's output file.
+func writeLRC(t *Track) error {
+	if t.SyncedLyrics == "" {
+		return nil
+	}
+	path := sidecarPath(t, ".lrc")
+	return os.WriteFile(path, []byte(t.SyncedLyrics), 0644)
+}
@@ -0,0 +1,157 @@
+// Package lyrics looks up plain and synced (LRC) lyrics on LRCLIB, caching
+// answers on disk so repeated runs over the same set stay offline.
+package lyrics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultBaseURL = "https://lrclib.net/api"
+
+// Lyrics is one track's lyrics. Synced is in LRC format and may be empty
+// when only plain lyrics are known.
+type Lyrics struct {
+	Plain        string `json:"plain"`
+	Synced       string `json:"synced"`
+	Instrumental bool   `json:"instrumental"`
+}
+
+// Client queries LRCLIB. The zero value works without a cache.
+type Client struct {
+	// CacheDir stores one JSON file per lookup, misses included. Empty
+	// disables caching.
+	CacheDir string
+	// BaseURL defaults to the public LRCLIB API.
+	BaseURL string
+	HTTP    *http.Client
+	// UserAgent identifies the caller, as LRCLIB asks clients to do.
+	UserAgent string
+}
+
+type cacheEntry struct {
+	Found  bool      `json:"found"`
+	Lyrics Lyrics    `json:"lyrics"`
+	Stored time.Time `json:"stored"`
+}
+
+type searchResult struct {
+	TrackName    string `json:"trackName"`
+	ArtistName   string `json:"artistName"`
+	Instrumental bool   `json:"instrumental"`
+	PlainLyrics  string `json:"plainLyrics"`
+	SyncedLyrics string `json:"syncedLyrics"`
+}
+
+// Lookup returns the lyrics for artist/title, or nil when LRCLIB has none.
+func (c *Client) Lookup(ctx context.Context, artist, title string) (*Lyrics, error) {
+	key := cacheKey(artist, title)
+	if entry, ok := c.readCache(key); ok {
+		if !entry.Found {
+			return nil, nil
+		}
+		return &entry.Lyrics, nil
+	}
+
+	lyr, err := c.search(ctx, artist, title)
+	if err != nil {
+		return nil, err
+	}
+	entry := cacheEntry{Found: lyr != nil, Stored: time.Now()}
+	if lyr != nil {
+		entry.Lyrics = *lyr
+	}
+	c.writeCache(key, entry)
+	return lyr, nil
+}
+
+func (c *Client) search(ctx context.Context, artist, title string) (*Lyrics, error) {
+	base := c.BaseURL
+	if base == "" {
+		base = defaultBaseURL
+	}
+	q := url.Values{"artist_name": {artist}, "track_name": {title}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/search?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lrclib search: %s", resp.Status)
+	}
+
+	var results []searchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("lrclib search: %v", err)
+	}
+
+	// Prefer a result with synced lyrics, then any with plain lyrics.
+	var best *searchResult
+	for i := range results {
+		r := &results[i]
+		if r.SyncedLyrics != "" {
+			best = r
+			break
+		}
+		if best == nil && (r.PlainLyrics != "" || r.Instrumental) {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	return &Lyrics{Plain: best.PlainLyrics, Synced: best.SyncedLyrics, Instrumental: best.Instrumental}, nil
+}
+
+func cacheKey(artist, title string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(artist) + "\x00" + strings.ToLower(title)))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (c *Client) readCache(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	if c.CacheDir == "" {
+		return entry, false
+	}
+	data, err := os.ReadFile(filepath.Join(c.CacheDir, key+".json"))
+	if err != nil {
+		return entry, false
+	}
+	return entry, json.Unmarshal(data, &entry) == nil
+}
+
+// writeCache is best effort: a failed write only costs a repeat lookup.
+func (c *Client) writeCache(key string, entry cacheEntry) {
+	if c.CacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(c.CacheDir, 0755); err != nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(c.CacheDir, key+".json"), data, 0644)
+}
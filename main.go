@@ -1,398 +1,435 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
-	"regexp"
-	"strconv"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"syscall"
+	"time"
+)
 
-	"github.com/cheggaaa/pb/v3"
+var (
+	opts       Options
+	inputParts inputList
+	batchPath  = flag.String("batch", "", "Path to a JSON manifest of input/tracklist/album jobs to run in sequence")
+	download   = flag.Bool("download", false, "Download an http(s) --input to a temporary file before splitting instead of seeking over the network")
+
+	confirmEstimates = flag.Bool("confirm-estimates", false, "Ask before splitting when some start times had to be estimated")
+	confirmRun       = flag.Bool("confirm", false, "Estimate size and encode time from a sample encode and ask before splitting")
+	resume           = flag.Bool("resume", false, "Continue an interrupted run in its existing output directory, skipping the tracks it finished")
 )
 
-type Track struct {
-	StartTime      float64
-	EndTime        float64
-	MainArtist     string
-	MainTitle      string
-	MainLabel      string
-	Additional     []AdditionalTrack
-	OutputFilename string
+func init() {
+	flag.StringVar(&opts.Tracklist, "tracklist", "", "Path to tracklist file")
+	flag.BoolVar(&opts.Audio, "audio", false, "Output audio (mp3); with --video too, both are written to audio/ and video/")
+	flag.BoolVar(&opts.Video, "video", false, "Output video (mp4, or webm with --format)")
+	flag.StringVar(&opts.Overrides, "overrides", "", "JSON file of per-track corrections keyed by track number or title")
+	flag.Var(&inputParts, "input", "Input media file or http(s) URL, or - to read it from stdin; repeat to join the files of a recording split into parts")
+	flag.StringVar(&opts.AudioStream, "audio-stream", "", "Audio stream of the input to use, by index (0, 1, ...) or language such as eng (default: the first)")
+	flag.StringVar(&opts.VideoStream, "video-stream", "", "Video stream of the input to use with --video, by index or language (default: the first)")
+	flag.StringVar(&opts.Archive, "archive", "", "Bundle each output directory into an archive named after the album: zip or tar.gz")
+	flag.BoolVar(&opts.ArchiveOnly, "archive-only", false, "Delete the loose files once they are in the --archive")
+	flag.StringVar(&opts.Upload, "upload", "", "Upload each output as it completes to s3://bucket/prefix (any S3-compatible service) or webdav://host/path")
+	flag.Var(&opts.Align, "align", "Reference audio of a track, as N=path (or path for track 1), located in the input to correct all start times; repeat for drift correction")
+	flag.DurationVar(&opts.AlignWindow, "align-window", time.Minute, "How far from its tracklist start each --align reference is searched for")
+	flag.StringVar(&opts.RecordingStart, "recording-start", "", "Time of day the recording began, HH:MM or HH:MM:SS, when the tracklist's timestamps are times of day, as in a radio cue log")
+	flag.BoolVar(&opts.MeasureDuration, "measure-duration", false, "Read the input through to measure its length instead of trusting its header; done anyway for MP3, AAC and MPEG-TS files")
+	flag.BoolVar(&opts.FailFast, "fail-fast", false, "Stop the whole run at the first track that fails, instead of splitting the rest")
+	flag.BoolVar(&opts.DetectBoundaries, "detect-boundaries", false, "Place tracks without a timestamp at transitions found in the audio instead of spreading them evenly; uncertain ones count as estimates")
+	bindProcessingFlags(flag.CommandLine, &opts)
+	bindSchedulerFlags(flag.CommandLine)
 }
 
-type AdditionalTrack struct {
-	Artist string
-	Title  string
-	Label  string
+// bindProcessingFlags registers the flags that shape how a job is processed,
+// as opposed to which input it reads. Serve mode binds them too, as defaults
+// for every submitted job.
+func bindProcessingFlags(fs *flag.FlagSet, o *Options) {
+	bindTagFlags(fs, o)
+	fs.StringVar(&o.Format, "format", "", "Comma-separated output formats: mp3, flac or opus for --audio (default mp3), mp4 or webm for --video (default mp4)")
+	fs.StringVar(&o.VideoCodec, "video-codec", "", "WebM video codec: vp9 or av1 (default vp9)")
+	fs.BoolVar(&o.VideoCopy, "video-copy", false, "With --video, copy the video stream instead of re-encoding it, moving each cut to the nearest keyframe")
+	fs.StringVar(&o.Channels, "channels", "", "Output channels: mono, stereo or keep (default: keep for audio, stereo for video)")
+	fs.BoolVar(&o.KeepChannels, "keep-channels", false, "Keep surround sound instead of downmixing: E-AC-3 in MP4, Opus in WebM, and FLAC or Opus for --audio")
+	fs.StringVar(&o.SampleRate, "sample-rate", "", "Output sample rate in Hz, or keep (default: keep for audio, 48000 for video)")
+	fs.BoolVar(&o.Analyze, "analyze", false, "Measure each track's loudness (LUFS, true peak, loudness range) into report.json; on its own, without --audio or --video, nothing is encoded")
+	fs.BoolVar(&o.AnalyzeTags, "analyze-tags", false, "With --analyze, also write ReplayGain tags from the measurement")
+	fs.DurationVar(&o.Ends.MaxLength, "max-track-length", 0, "Cut tracks off this long after they start, e.g. 15m for a set ending in a long outro")
+	fs.DurationVar(&o.Ends.Gap, "track-gap", 0, "End each track this long before the next one starts, leaving out applause or talking between them, e.g. 5s")
+	fs.DurationVar(&o.DetectSilence, "detect-silence", 0, "Also trim silences at least this long from the start and end of tracks, e.g. 2s")
+	fs.DurationVar(&o.Preview, "preview", 0, "Render only a clip this long from the middle of each track, fast and at low quality, e.g. 15s")
+	fs.StringVar(&o.Waveforms, "waveforms", "", "Render waveform PNGs: tracks (one per output), set (the whole recording with cut points marked) or both")
+	fs.StringVar(&o.Transform.Crop, "crop", "", "With --video, crop the video to W:H (centred) or W:H:X:Y pixels of the source, e.g. to cut off letterboxing or a stream overlay")
+	fs.IntVar(&o.Transform.Rotate, "rotate", 0, "With --video, rotate the video clockwise by 90, 180 or 270 degrees")
+	fs.StringVar(&o.Transform.Scale, "scale", "", "With --video, scale the video to a height such as 720p or a size such as 1280x720")
+	fs.DurationVar(&o.TitleOverlay, "title-overlay", 0, "With --video, burn the artist and title (and any \"w/\" tracks) into the first this long of each track, e.g. 8s")
+	fs.DurationVar(&o.OverlayFade, "overlay-fade", 500*time.Millisecond, "How long the title overlay takes to fade in and out")
+	fs.StringVar(&o.OverlayFont, "overlay-font", "", "Font file for the title overlay (default: ffmpeg's default font)")
+	fs.StringVar(&o.OverlayPosition, "overlay-position", OverlayBottomLeft, "Corner of the title overlay: bottom-left, bottom-right, top-left or top-right")
+	fs.BoolVar(&o.Thumbnails, "thumbnails", false, "With --video, save a JPEG a few seconds into each track next to it and embed it as the cover")
+	fs.BoolVar(&o.Spectrograms, "spectrograms", false, "Render a .spectrogram.png of each output to check source quality, e.g. the low-pass cutoff of a lossy transcode")
+	fs.StringVar(&o.StemsCommand, "stems", "", "With --audio, shell command separating each track into stems, run with the track as $SPLITTER_TRACK_PATH; the audio files it writes under $SPLITTER_STEMS_DIR are encoded into a folder per stem")
+	fs.BoolVar(&o.NML, "nml", false, "Write <album>.nml, a Traktor collection of the outputs with their tags and start times in the recording")
+	fs.BoolVar(&o.Verify, "verify", false, "Probe and decode every output afterwards, flagging truncated, silent or broken files")
+	fs.StringVar(&o.Checksums, "checksums", "", "Record SHA-256 checksums of the outputs and source: sums (a SHA256SUMS file) or files (a .sha256 file per output)")
+	fs.StringVar(&o.PreTrackHook, "pre-track-hook", "", "Shell command run before each track; a non-zero exit skips the track")
+	fs.StringVar(&o.PostTrackHook, "post-track-hook", "", "Shell command run after each track finishes or fails")
+	fs.StringVar(&o.PostRunHook, "post-run-hook", "", "Shell command run once all tracks are done")
+	fs.StringVar(&o.NotifyURL, "notify-url", "", "Webhook POSTed the JSON report when a run ends; Discord and Slack webhooks get a summary message")
+	fs.BoolVar(&o.NotifyDesktop, "notify-desktop", false, "Show a desktop notification when a run ends")
 }
 
-var (
-	tracklistPath = flag.String("tracklist", "", "Path to tracklist file")
-	audioFlag     = flag.Bool("audio", false, "Output audio (mp3)")
-	videoFlag     = flag.Bool("video", false, "Output video (mp4)")
-	inputPath     = flag.String("input", "", "Input media file")
-)
+// bindTagFlags registers the flags deciding the tags and filenames of the
+// outputs, which the tag command takes as well.
+func bindTagFlags(fs *flag.FlagSet, o *Options) {
+	fs.BoolVar(&o.StripVersion, "strip-version", false, "Drop \"(Extended Mix)\"-style suffixes from the title tag; they are always written to the version tag")
+	fs.StringVar(&o.Album, "album", "", "Album tag (default: the first line of the tracklist)")
+	fs.StringVar(&o.Date, "date", "", "Release or recording date tag, as YYYY, YYYY-MM or YYYY-MM-DD")
+	fs.StringVar(&o.Year, "year", "", "Year tag; shorthand for --date YYYY (default: a year found in the album name)")
+	fs.StringVar(&o.AlbumArtist, "album-artist", "", "Album artist written to every track, e.g. the DJ (default: each track's own artists)")
+	fs.StringVar(&o.Genre, "genre", "", "Genre tag written to every track")
+	fs.Var(&o.Tags, "tag", "Extra key=value tag written to every track; repeatable, and overrides generated tags")
+	fs.StringVar(&o.Disc, "disc", "", "Disc number as X or X/Y for sets recorded in several parts")
+	fs.BoolVar(&o.Compilation, "compilation", false, "Mark outputs as a compilation; the album artist defaults to \"Various Artists\"")
+	fs.IntVar(&o.ID3Version, "id3-version", 0, "ID3v2 version for MP3 tags, 3 or 4 (default: ffmpeg's, 4)")
+	fs.StringVar(&o.ID3Encoding, "id3-encoding", "", "MP3 tag text encoding, utf16 (implies ID3v2.3) or utf8 (implies ID3v2.4)")
+	fs.BoolVar(&o.ID3v1, "id3v1", false, "Also write an ID3v1 tag to MP3s")
+	fs.StringVar(&o.Lyrics, "lyrics", "", "Fetch lyrics from LRCLIB: embed (lyrics tag), lrc (synced .lrc sidecar files) or both")
+	fs.StringVar(&o.LyricsCache, "lyrics-cache", "", "Directory caching lyrics lookups (default: the user cache directory)")
+	fs.StringVar(&o.Duplicates, "duplicates", DuplicatesMerge, "Tracks starting when the track before does: merge (into it, as played together) or error")
+	fs.StringVar(&o.Segments, "segments", SegmentsExclude, "Announcements and breaks marked in the tracklist (\"MC\", \"Break\", \"... On Stage\"): exclude (cut them out), bonus (write each as its own file) or include (leave them in the track before)")
+	fs.StringVar(&o.Filenames.Mode, "filenames", platformFilenames(), "Filename policy: default, windows (Windows-safe) or ascii (Windows-safe and transliterated)")
+	fs.StringVar(&o.Filenames.Replacement, "filename-replacement", "", "Put this in place of unsafe filename characters instead of dropping them")
+	fs.IntVar(&o.Filenames.MaxLength, "filename-max-length", 0, "Truncate filenames to this many characters, extension included (default: no limit)")
+}
 
 const (
-	maxWorkers    = 4
-	outputDir     = "output"
-	timeFormat    = "15:04:05"
-	metadataAlbum = "Ultra Europe 2025"
+	maxWorkers = 4
+	outputDir  = "output"
+	timeFormat = "15:04:05"
 )
 
+// subcommand is an alternative entry point selected by the first argument.
+type subcommand struct {
+	// summary is its line in the --help overview.
+	summary string
+	// setup registers the subcommand's flags and returns the function run
+	// once they are parsed, so completion can list the flags without
+	// running anything.
+	setup func(flags *flag.FlagSet) func(logger *slog.Logger) error
+}
 
+// subcommands maps the first argument to an alternative entry point. Each
+// parses its own flags from the remaining arguments; anything else runs the
+// default split.
+var subcommands = map[string]subcommand{
+	"cues":       {"Write the tracklist as Rekordbox or Traktor cue points on the recording", cuesCommand},
+	"identify":   {"Propose a tracklist for a mix by fingerprinting it", identifyCommand},
+	"merge":      {"Join split tracks back into one file with chapters", mergeCommand},
+	"serve":      {"Run the web UI and REST API", serveCommand},
+	"soundcloud": {"Build a tracklist from a SoundCloud set's comments and download it", soundCloudCommand},
+	"spotify":    {"Create a Spotify playlist of a tracklist", spotifyCommand},
+	"tag":        {"Rewrite the tags and names of split tracks without re-encoding them", tagCommand},
+	"validate":   {"Check a tracklist without splitting anything", validateCommand},
+}
 
 func main() {
-	flag.Parse()
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	if err := validateFlags(); err != nil {
-		logger.Error("Validation error", "error", err)
-		os.Exit(1)
-	}
-
-	tracks, album, err := parseTracklist(*tracklistPath)
-	if err != nil {
-		logger.Error("Failed to parse tracklist", "error", err)
-		os.Exit(1)
-	}
-
-  logger.Info("Parsed tracklist", "album", album, "trackCount", len(tracks))
-
-	duration, err := getMediaDuration(*inputPath)
-	if err != nil {
-		logger.Error("Failed to get media duration", "error", err)
-		os.Exit(1)
-	}
-
-	if err := prepareOutputDir(); err != nil {
-		logger.Error("Output directory preparation failed", "error", err)
-		os.Exit(1)
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+			run := cmd.setup(flags)
+			flags.Parse(os.Args[2:])
+			if err := run(logger); err != nil {
+				logger.Error("Command failed", "command", os.Args[1], "error", err)
+				os.Exit(1)
+			}
+			return
+		}
 	}
 
-	calculateEndTimes(tracks, duration)
-
-	outputExt := getOutputExtension()
-	createFilenames(tracks, outputExt)
-
-	processTracksConcurrently(tracks, *inputPath, outputExt, album, logger)
+	flag.Usage = printUsage
+	flag.Parse()
+	os.Exit(runSplit(logger))
 }
 
-func validateFlags() error {
-	if *tracklistPath == "" || *inputPath == "" {
-		return errors.New("both --tracklist and --input are required")
-	}
-	if !*audioFlag && !*videoFlag {
-		return errors.New("either --audio or --video must be specified")
+// Exit codes of the split command, for scripts to tell outcomes apart. Any
+// other failure, such as an output directory that cannot be created or a
+// declined confirmation, exits with 1.
+const (
+	exitOK = 0
+	// exitFailed is some tracks failing while the rest were split.
+	exitFailed = 2
+	// exitInvalid is a run stopped before splitting by invalid flags, a
+	// tracklist with errors or an input that cannot be planned.
+	exitInvalid = 3
+	// exitInterrupted is a run stopped by Ctrl+C or SIGTERM, as shells
+	// report a process killed by SIGINT.
+	exitInterrupted = 130
+)
+
+// runSplit is the default command. It returns the process exit code so that
+// deferred cleanup still runs.
+func runSplit(logger *slog.Logger) int {
+	// Several parts are joined into one input once the flags check out.
+	if len(inputParts) == 1 {
+		opts.Input = inputParts[0]
 	}
-	if *audioFlag && *videoFlag {
-		return errors.New("cannot specify both --audio and --video")
+	if err := validateFlags(); err != nil {
+		logger.Error("Validation error", "error", err)
+		return exitInvalid
 	}
-	return nil
-}
-
-func parseTracklist(path string) ([]Track, string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, "", err
+	if opts.OutputDir == "" {
+		opts.OutputDir = outputDir
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	scanner.Scan()
-	header := scanner.Text()
-
-	var tracks []Track
-	currentTrack := (*Track)(nil)
-	lineRe := regexp.MustCompile(`^\[(\d+:?\d*:\d+)\]\s(.+?)(?:\s\[(.+)\])?$`)
-	wRe := regexp.MustCompile(`^w/\s(.+?)(?:\s\[(.+)\])?$`)
+	// Set up cleanup on interrupt. It is in place before the first ffmpeg
+	// process starts, so none is left running; a second interrupt quits
+	// at once, such as at a prompt.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interruptChan
+		logger.Info("Received interrupt signal, cleaning up...")
+		signal.Stop(interruptChan)
+		cancel()
+	}()
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	var jobs []*Job
+	stdinUsed := false
+	if *batchPath != "" {
+		entries, err := loadManifest(*batchPath)
+		if err != nil {
+			logger.Error("Failed to load manifest", "error", err)
+			return exitInvalid
 		}
-
-		if matches := lineRe.FindStringSubmatch(line); matches != nil {
-			if currentTrack != nil {
-				tracks = append(tracks, *currentTrack)
-			}
-
-			start, err := parseTimestamp(matches[1])
+		if jobs, err = planBatch(entries, opts); err != nil {
+			logger.Error("Failed to plan batch", "error", err)
+			return exitInvalid
+		}
+	} else {
+		if len(inputParts) > 1 {
+			logger.Info("Joining input parts", "parts", len(inputParts))
+			path, err := concatInputs(inputParts)
 			if err != nil {
-				return nil, "", err
-			}
-
-			// Skip stage announcement lines
-			if strings.HasSuffix(matches[2], "On Stage") {
-				continue
+				logger.Error("Failed to join input parts", "error", err)
+				return exitInvalid
 			}
-
-			artist, title, err := parseArtistTitle(matches[2])
+			defer os.Remove(path)
+			opts.Input, opts.InputParts = path, inputParts
+		} else if opts.Input == stdinInput {
+			logger.Info("Buffering input from stdin")
+			path, err := bufferStdin()
 			if err != nil {
-				return nil, "", err
+				logger.Error("Failed to read input", "error", err)
+				return 1
 			}
-
-			label := ""
-			if len(matches) > 3 && matches[3] != "" {
-				label = matches[3]
+			defer os.Remove(path)
+			opts.Input = path
+			stdinUsed = true
+		} else if *download && isURL(opts.Input) {
+			logger.Info("Downloading input", "url", opts.Input)
+			path, err := downloadInput(ctx, opts.Input)
+			if err != nil {
+				logger.Error("Failed to download input", "error", err)
+				return 1
 			}
+			defer os.Remove(path)
+			opts.Input = path
+		}
 
-			currentTrack = &Track{
-				StartTime:  start,
-				MainArtist: artist,
-				MainTitle:  title,
-				MainLabel:  label,
-			}
-		} else if strings.HasPrefix(line, "w/") {
-			if currentTrack == nil {
-				continue
-			}
+		job, err := planJobFile(opts)
+		if err != nil {
+			logger.Error("Failed to plan job", "error", err)
+			return exitInvalid
+		}
+		jobs = append(jobs, job)
+	}
 
-			matches := wRe.FindStringSubmatch(line)
-			if matches == nil {
-				continue
+	for _, job := range jobs {
+		logger.Info("Parsed tracklist", "album", job.Album, "trackCount", len(job.Tracks))
+		if job.hasStreamChoice() {
+			for _, s := range job.streams {
+				logger.Info("Input stream", "stream", s.String(), "selected", s == job.audioStream || s == job.videoStream)
 			}
-
-			artist, title, err := parseArtistTitle(matches[1])
-			if err != nil {
-				return nil, "", err
+		}
+		for _, p := range job.Alignment {
+			logger.Info("Aligned tracklist", "track", p.Track, "offset", fmt.Sprintf("%+.2fs", p.Offset), "score", fmt.Sprintf("%.2f", p.Score))
+		}
+		for _, t := range job.Tracks {
+			if t.Drift != 0 {
+				logger.Info("Moved start to keyframe", "track", t.Number, "drift", fmt.Sprintf("%+.3fs", t.Drift))
 			}
-
-			currentTrack.Additional = append(currentTrack.Additional, AdditionalTrack{
-				Artist: artist,
-				Title:  title,
-				Label:  matches[2],
-			})
+		}
+		for _, d := range job.Warnings {
+			logger.Warn("Tracklist warning", "line", d.Line, "problem", d.Message, "fix", d.Fix)
 		}
 	}
-
-	if currentTrack != nil {
-		tracks = append(tracks, *currentTrack)
+	if *confirmEstimates {
+		if err := confirmEstimatedStarts(jobs, !stdinUsed); err != nil {
+			logger.Error("Estimated start times not confirmed", "error", err)
+			return 1
+		}
 	}
 
-  
-
-	return tracks, header, scanner.Err()
-}
-
-func parseTimestamp(ts string) (float64, error) {
-	parts := strings.Split(ts, ":")
-	var total float64
+	planned := jobs
+	var formatJobs []*Job
+	for _, job := range jobs {
+		formatJobs = append(formatJobs, job.splitFormats()...)
+	}
+	jobs = formatJobs
 
-	// Handle different time formats (MM:SS or HH:MM:SS)
-	multipliers := []float64{1, 60, 3600}
-	for i := range parts {
-		val, err := strconv.Atoi(parts[len(parts)-1-i])
-		if err != nil {
-			return 0, err
+	writeSummary(ctx, os.Stdout, planned, jobs, *confirmRun)
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	if *confirmRun && !stdinUsed {
+		if err := confirmSummary(); err != nil {
+			logger.Error("Split not confirmed", "error", err)
+			return 1
 		}
-		total += float64(val) * multipliers[i]
 	}
-	return total, nil
-}
 
-func parseArtistTitle(s string) (string, string, error) {
-	parts := strings.SplitN(s, " - ", 2)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid artist/title format: %s", s)
+	if *resume {
+		if err := os.MkdirAll(opts.OutputDir, 0755); err != nil {
+			logger.Error("Output directory preparation failed", "error", err)
+			return 1
+		}
+	} else if err := prepareOutputDir(opts.OutputDir, !stdinUsed); err != nil {
+		logger.Error("Output directory preparation failed", "error", err)
+		return 1
 	}
-	return parts[0], parts[1], nil
-}
-
-func getMediaDuration(path string) (float64, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", 
-		"format=duration", "-of", "default=noprint_wrappers=1:nokey=1", path)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, fmt.Errorf("ffprobe error: %v", err)
+	for _, job := range jobs {
+		if err := job.openCheckpoint(*resume); err != nil {
+			logger.Error("Cannot resume", "album", job.Album, "error", err)
+			return exitInvalid
+		}
 	}
 
-	return strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-}
-
-func prepareOutputDir() error {
-	if _, err := os.Stat(outputDir); err == nil {
-		fmt.Print("Output directory exists. Delete it? (y/n): ")
-		var response string
-		fmt.Scanln(&response)
-		if strings.ToLower(response) != "y" {
-			return errors.New("user cancelled operation")
+	progress := startProgress(jobs)
+	errCount := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil || (opts.FailFast && errCount > 0) {
+			break
 		}
-		if err := os.RemoveAll(outputDir); err != nil {
-			return err
+		// Siblings are written to by this job, so they need their
+		// directories now.
+		if err := mkdirJobs(append([]*Job{job}, job.siblings...)); err != nil {
+			logger.Error("Creating job output directory failed", "album", job.Album, "error", err)
+			errCount += len(job.Tracks)
+			continue
 		}
+
+		progress.watch(job)
+		errCount += runJob(ctx, job, logger)
 	}
-	return os.Mkdir(outputDir, 0755)
-}
+	progress.finish()
 
-func calculateEndTimes(tracks []Track, duration float64) {
-	for i := range tracks {
-		if i < len(tracks)-1 {
-			tracks[i].EndTime = tracks[i+1].StartTime
-		} else {
-			tracks[i].EndTime = duration
+	for _, job := range jobs {
+		if job.Analyze {
+			fmt.Printf("\n%s (%s)\n", job.Album, job.OutputDir)
+			writeLoudnessSummary(os.Stdout, job)
 		}
 	}
-}
 
-func getOutputExtension() string {
-	if *audioFlag {
-		return ".mp3"
+	if ctx.Err() != nil {
+		logger.Error("Interrupted", "errorCount", errCount)
+		return exitInterrupted
 	}
-	return ".mp4"
-}
-
-func createFilenames(tracks []Track, ext string) {
-	for i := range tracks {
-		tracks[i].OutputFilename = fmt.Sprintf("output/%02d - %s - %s%s",
-			i+1, sanitizeFilename(tracks[i].MainArtist), sanitizeFilename(tracks[i].MainTitle), ext)
+	if errCount > 0 {
+		logger.Error("Completed with errors", "errorCount", errCount)
+		return exitFailed
 	}
+	return exitOK
 }
 
-func sanitizeFilename(name string) string {
-	return strings.Map(func(r rune) rune {
-		if strings.ContainsRune(`<>:"/\|?*`, r) {
-			return -1
+func mkdirJobs(jobs []*Job) error {
+	for _, job := range jobs {
+		if err := os.MkdirAll(job.OutputDir, 0755); err != nil {
+			return err
 		}
-		return r
-	}, name)
-}
-
-func processTracksConcurrently(tracks []Track, inputPath, ext, album string, logger *slog.Logger) {
-	bar := pb.StartNew(len(tracks))
-	defer bar.Finish()
-
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, maxWorkers)
-	var errCount atomic.Int32
-
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Set up cleanup on interrupt
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-interruptChan
-		logger.Info("Received interrupt signal, cleaning up...")
-		cancel()
-	}()
-
-	for i := range tracks {
-		wg.Add(1)
-		go func(t *Track) {
-			defer wg.Done()
-			select {
-			case sem <- struct{}{}:
-				defer func() { <-sem }()
-				if err := processTrack(ctx, t, inputPath, album); err != nil {
-					logger.Error("Track processing failed", 
-						"track", t.MainTitle, "error", err)
-					errCount.Add(1)
-				}
-				bar.Increment()
-			case <-ctx.Done():
-				return
-			}
-		}(&tracks[i])
 	}
+	return nil
+}
 
-	wg.Wait()
-
-	if errCount.Load() > 0 {
-		logger.Error("Completed with errors", "errorCount", errCount.Load())
+// planJobFile plans a job from the tracklist file named in o.
+func planJobFile(o Options) (*Job, error) {
+	file, err := os.Open(o.Tracklist)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
+	return newJob(o, file)
 }
 
-func processTrack(ctx context.Context, t *Track, inputPath, album string) error {
-	// Validate time values
-	if t.StartTime >= t.EndTime {
-		return fmt.Errorf("invalid time range: start(%f) >= end(%f)", t.StartTime, t.EndTime)
+func validateFlags() error {
+	if *batchPath != "" {
+		if opts.Tracklist != "" || len(inputParts) > 0 {
+			return errors.New("--batch cannot be combined with --tracklist or --input")
+		}
+	} else if opts.Tracklist == "" || len(inputParts) == 0 {
+		return errors.New("both --tracklist and --input are required")
 	}
-
-	args := []string{
-		"-v", "warning",              // Show warnings for debugging
-		"-ss", fmt.Sprintf("%f", t.StartTime),
-		"-i", inputPath,
-		"-t", fmt.Sprintf("%f", t.EndTime-t.StartTime),
-		
-		// Memory management and optimization
-		"-max_muxing_queue_size", "1024",
-		"-threads", "2",              // Limit threads per process
+	if !opts.Audio && !opts.Video && !opts.Analyze {
+		return errors.New("either --audio, --video or --analyze must be specified")
 	}
+	return procs.validate()
+}
 
-	if *videoFlag {
-		args = append(args,
-			"-c:v", "libx264",           // Use H.264 codec
-			"-preset", "veryfast",       // Use faster preset to reduce memory usage
-			"-crf", "23",               // Reasonable quality
-			"-vsync", "cfr",            // Force constant frame rate
-			"-profile:v", "baseline",   // Use baseline profile for better compatibility and less memory
-			"-level", "3.0",            // Lower level for less memory usage
-			"-tune", "fastdecode",      // Optimize for decoding speed
-			"-c:a", "aac",              // AAC audio codec
-			"-b:a", "192k",             // Audio bitrate
-			"-ac", "2",                 // Force stereo
-			"-ar", "48000",             // Standard sample rate
-			"-movflags", "+faststart",  // Enable fast start
-			"-y",                        // Overwrite output
-		)
-	} else {
-		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+// confirmEstimatedStarts lists the interpolated start times and asks before
+// going ahead with them.
+func confirmEstimatedStarts(jobs []*Job, interactive bool) error {
+	var estimated []Track
+	for _, job := range jobs {
+		for _, t := range job.Tracks {
+			if t.Estimated {
+				estimated = append(estimated, t)
+			}
+		}
 	}
-
-	metadata := buildMetadata(t, album)
-	args = append(args, metadata...)
-	args = append(args, t.OutputFilename)
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("ffmpeg error: %v\n%s", err, string(output))
+	if len(estimated) == 0 {
+		return nil
 	}
-	return nil
-}
-
-func buildMetadata(t *Track, album string) []string {
-	metadata := []string{
-		"-metadata", fmt.Sprintf("title=%s", buildTitle(t)),
-		"-metadata", fmt.Sprintf("artist=%s", t.MainArtist),
-		"-metadata", fmt.Sprintf("album=%s", album),
-		"-metadata", fmt.Sprintf("date=%s", "2025"),
-		"-metadata", fmt.Sprintf("comment=%s", buildComment(t)),
+	if !interactive {
+		return fmt.Errorf("%d start times are estimated", len(estimated))
 	}
 
-	if t.MainLabel != "" {
-		metadata = append(metadata, "-metadata", fmt.Sprintf("publisher=%s", t.MainLabel))
+	fmt.Println("These start times were estimated:")
+	for _, t := range estimated {
+		fmt.Printf("  line %d: [%s] %s - %s\n", t.Line, formatTimestamp(t.StartTime), t.MainArtist, t.MainTitle)
 	}
-
-	return metadata
-}
-
-func buildTitle(t *Track) string {
-	title := t.MainTitle
-	for _, add := range t.Additional {
-		title += " / " + add.Title
+	fmt.Print("Split with these start times? (y/n): ")
+	var response string
+	fmt.Scanln(&response)
+	if strings.ToLower(response) != "y" {
+		return errors.New("user cancelled operation")
 	}
-	return title
+	return nil
 }
 
-func buildComment(t *Track) string {
-	var comments []string
-	for _, add := range t.Additional {
-		comments = append(comments, fmt.Sprintf("%s - %s [%s]", 
-			add.Artist, add.Title, add.Label))
+// prepareOutputDir creates dir, asking before deleting an existing one. When
+// stdin carries the input there is nobody to ask, so it refuses instead.
+func prepareOutputDir(dir string, interactive bool) error {
+	if _, err := os.Stat(dir); err == nil {
+		if !interactive {
+			return fmt.Errorf("output directory %s already exists", dir)
+		}
+		fmt.Print("Output directory exists. Delete it? (y/n): ")
+		var response string
+		fmt.Scanln(&response)
+		if strings.ToLower(response) != "y" {
+			return errors.New("user cancelled operation")
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
 	}
-	return "Additional tracks: " + strings.Join(comments, "; ")
+	return os.Mkdir(dir, 0755)
 }
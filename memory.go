@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// errOutOfMemory marks an ffmpeg failure that looks like it ran out of
+// memory, which is worth retrying with less parallelism.
+var errOutOfMemory = errors.New("ffmpeg ran out of memory")
+
+// oomRe matches the allocation failures ffmpeg and its encoders report.
+var oomRe = regexp.MustCompile(`(?i)cannot allocate memory|out of memory|bad_alloc|malloc of size \d+ failed`)
+
+// byteSize is a flag holding a size such as 512M or 2G, in bytes.
+type byteSize int64
+
+// String is the size in the largest unit it is a whole number of, as Set
+// reads it.
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return ""
+	}
+	n := int64(*b)
+	for _, unit := range "KMGT" {
+		if n%1024 != 0 {
+			break
+		}
+		n /= 1024
+		if n%1024 != 0 || unit == 'T' {
+			return strconv.FormatInt(n, 10) + string(unit)
+		}
+	}
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	units := map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	mult, ok := units[s[i:]]
+	if err != nil || !ok || n <= 0 {
+		return fmt.Errorf("invalid size %q: want a number with an optional K, M or G suffix", s)
+	}
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// outOfMemory reports whether a failed ffmpeg run looks like it ran out of
+// memory: the allocation errors ffmpeg prints, or a SIGKILL that did not
+// come from cancelling ctx, which is how the kernel's OOM killer ends it.
+func outOfMemory(ctx context.Context, err error, output []byte) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		if ws, ok := exit.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGKILL {
+			return true
+		}
+	}
+	return oomRe.Match(output)
+}
+
+// lowMemoryArgs rewrites an encode to use less memory: a single thread and
+// the fastest encoder presets, which keep fewer frames in flight.
+func lowMemoryArgs(args []string) []string {
+	args = append([]string(nil), args...)
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-threads":
+			args[i+1] = "1"
+		case "-preset":
+			// libsvtav1 takes a number, libx264 a name.
+			if _, err := strconv.Atoi(args[i+1]); err == nil {
+				args[i+1] = "12"
+			} else {
+				args[i+1] = "ultrafast"
+			}
+		case "-cpu-used":
+			args[i+1] = "8"
+		}
+	}
+	return args
+}
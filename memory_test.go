@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestByteSizeSet(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    byteSize
+		wantErr bool
+	}{
+		{in: "1048576", want: 1 << 20},
+		{in: "512K", want: 512 << 10},
+		{in: "512M", want: 512 << 20},
+		{in: "2G", want: 2 << 30},
+		{in: "2g", want: 2 << 30},
+		{in: "2GB", want: 2 << 30},
+		{in: " 1T ", want: 1 << 40},
+		{in: "1B", want: 1},
+		{in: "", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "-1G", wantErr: true},
+		{in: "G", wantErr: true},
+		{in: "1.5G", wantErr: true},
+		{in: "2P", wantErr: true},
+	}
+	for _, tt := range tests {
+		var b byteSize
+		err := b.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if b != tt.want {
+			t.Errorf("Set(%q) = %d, want %d", tt.in, b, tt.want)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	tests := []struct {
+		in   byteSize
+		want string
+	}{
+		{in: 0, want: ""},
+		{in: 1000, want: "1000"},
+		{in: 1536, want: "1536"},
+		{in: 512 << 10, want: "512K"},
+		{in: 10 << 30, want: "10G"},
+		{in: 3 << 30 / 2, want: "1536M"},
+		{in: 2048 << 40, want: "2048T"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("byteSize(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// mergeExtensions are the files merge picks up from the tracks directory.
+var mergeExtensions = map[string]bool{
+	".mp3": true, ".flac": true, ".opus": true, ".m4a": true, ".mp4": true, ".wav": true, ".ogg": true,
+}
+
+// numberPrefixRe matches the "NN - " createFilenames puts before each name.
+var numberPrefixRe = regexp.MustCompile(`^(\d+) - `)
+
+// mergePart is one file of the mix and the chapter it becomes.
+type mergePart struct {
+	Path     string
+	Title    string
+	Duration float64
+}
+
+// mergeCommand concatenates split tracks back into one continuous file with a
+// chapter per track, the reverse of a split.
+func mergeCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	dir := flags.String("dir", "", "Directory of tracks to merge")
+	tracklistPath := flags.String("tracklist", "", "Tracklist giving the order and chapter titles (default: filename order)")
+	output := flags.String("output", "", "File to write; its extension picks the format: .mp3, .m4a or .flac")
+	crossfade := flags.Duration("crossfade", 0, "Crossfade between tracks, such as 4s")
+	return func(logger *slog.Logger) error {
+		if *dir == "" || *output == "" {
+			return errors.New("both --dir and --output are required")
+		}
+		files, err := mergeFiles(*dir)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no tracks found in %s", *dir)
+		}
+
+		album := filepath.Base(filepath.Clean(*dir))
+		var parts []mergePart
+		if *tracklistPath != "" {
+			f, err := os.Open(*tracklistPath)
+			if err != nil {
+				return err
+			}
+			tracks, name, err := parseTracklist(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("parse tracklist: %v", err)
+			}
+			if name != "" {
+				album = name
+			}
+			if parts, err = orderByTracklist(files, musicTracks(tracks)); err != nil {
+				return err
+			}
+		} else {
+			for _, f := range files {
+				name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+				parts = append(parts, mergePart{Path: f, Title: numberPrefixRe.ReplaceAllString(name, "")})
+			}
+		}
+
+		for i := range parts {
+			if parts[i].Duration, err = getMediaDuration(parts[i].Path); err != nil {
+				return fmt.Errorf("%s: %v", parts[i].Path, err)
+			}
+			if xf := crossfade.Seconds(); xf > 0 && parts[i].Duration <= xf {
+				return fmt.Errorf("%s is shorter than the crossfade", parts[i].Path)
+			}
+		}
+
+		logger.Info("Merging tracks", "tracks", len(parts), "output", *output)
+		if err := mergeTracks(context.Background(), parts, album, crossfade.Seconds(), *output); err != nil {
+			return err
+		}
+		logger.Info("Wrote mix", "path", *output)
+		return nil
+	}
+}
+
+// mergeFiles lists the media files in dir in name order, which for a split
+// is track order.
+func mergeFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && mergeExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// orderByTracklist finds each track's file by artist and title, falling back
+// to its number prefix, so renamed or renumbered files still line up.
+func orderByTracklist(files []string, tracks []Track) ([]mergePart, error) {
+	byKey := make(map[string]string)
+	byNumber := make(map[string]string)
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
+		if m := numberPrefixRe.FindStringSubmatch(name); m != nil {
+			byNumber[strings.TrimLeft(m[1], "0")] = f
+			name = name[len(m[0]):]
+		}
+		byKey[normalizeTrackKey(name)] = f
+	}
+
+	parts := make([]mergePart, len(tracks))
+	for i, t := range tracks {
+		path, ok := byKey[normalizeTrackKey(t.MainArtist+t.MainTitle)]
+		if !ok {
+			path, ok = byNumber[fmt.Sprint(i+1)]
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: no file found for %s - %s", t.Line, t.MainArtist, t.MainTitle)
+		}
+		parts[i] = mergePart{Path: path, Title: t.MainArtist + " - " + buildTitle(&t)}
+	}
+	return parts, nil
+}
+
+// mergeTracks joins the parts with ffmpeg, crossfading xf seconds between
+// them, and adds a chapter for each.
+func mergeTracks(ctx context.Context, parts []mergePart, album string, xf float64, output string) error {
+	meta, err := os.CreateTemp("", "song-splitter-chapters-*.txt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(meta.Name())
+	_, err = meta.WriteString(chapterMetadata(parts, album, xf))
+	meta.Close()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-v", "warning"}
+	for _, p := range parts {
+		args = append(args, "-i", p.Path)
+	}
+	args = append(args, "-f", "ffmetadata", "-i", meta.Name())
+
+	var filter strings.Builder
+	if xf > 0 && len(parts) > 1 {
+		prev := "[0:a]"
+		for i := 1; i < len(parts); i++ {
+			out := fmt.Sprintf("[x%d]", i)
+			if i == len(parts)-1 {
+				out = "[out]"
+			}
+			fmt.Fprintf(&filter, "%s[%d:a]acrossfade=d=%f%s;", prev, i, xf, out)
+			prev = out
+		}
+	} else {
+		for i := range parts {
+			fmt.Fprintf(&filter, "[%d:a]", i)
+		}
+		fmt.Fprintf(&filter, "concat=n=%d:v=0:a=1[out];", len(parts))
+	}
+
+	args = append(args,
+		"-filter_complex", strings.TrimSuffix(filter.String(), ";"),
+		"-map", "[out]",
+		"-map_metadata", fmt.Sprint(len(parts)),
+		"-map_chapters", fmt.Sprint(len(parts)),
+	)
+	switch strings.ToLower(filepath.Ext(output)) {
+	case ".mp3":
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2", "-id3v2_version", "3")
+	case ".m4a", ".mp4":
+		args = append(args, "-c:a", "aac", "-b:a", "256k", "-movflags", "+faststart")
+	case ".flac":
+		args = append(args, "-c:a", "flac")
+	default:
+		return fmt.Errorf("unsupported output format %q: want .mp3, .m4a or .flac", filepath.Ext(output))
+	}
+	args = append(args, "-y", output)
+
+	if out, err := ffmpegOutput(ctx, args...); err != nil {
+		return fmt.Errorf("ffmpeg error: %v\n%s", err, string(out))
+	}
+	return nil
+}
+
+// chapterMetadata is an FFMETADATA file with the album title and a chapter
+// per part. Each crossfade overlaps two parts by xf seconds, and the chapter
+// changes halfway through it.
+func chapterMetadata(parts []mergePart, album string, xf float64) string {
+	starts := make([]float64, len(parts)+1)
+	offset := 0.0
+	for i, p := range parts {
+		if i > 0 {
+			starts[i] = offset + xf/2
+		}
+		offset += p.Duration - xf
+	}
+	starts[len(parts)] = offset + xf
+
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	fmt.Fprintf(&b, "title=%s\nalbum=%s\n", escapeFFMetadata(album), escapeFFMetadata(album))
+	for i, p := range parts {
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(starts[i]*1000), int64(starts[i+1]*1000), escapeFFMetadata(p.Title))
+	}
+	return b.String()
+}
+
+// escapeFFMetadata backslash-escapes the characters special to FFMETADATA.
+func escapeFFMetadata(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+	return r.Replace(s)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// tagList is a repeatable "key=value" flag.
+type tagList []string
+
+func (l *tagList) String() string { return strings.Join(*l, ", ") }
+
+func (l *tagList) Set(v string) error {
+	key, _, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return errors.New("tag must be key=value")
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+func buildMetadata(t *Track, job *Job) []string {
+	metadata := []string{
+		"-metadata", fmt.Sprintf("title=%s", buildTagTitle(t, job)),
+		"-metadata", fmt.Sprintf("artist=%s", t.MainArtist),
+		"-metadata", fmt.Sprintf("album_artist=%s", albumArtist(t, job)),
+		"-metadata", fmt.Sprintf("ARTISTS=%s", strings.Join(t.Credits.AllArtists(), "; ")),
+		"-metadata", fmt.Sprintf("album=%s", job.Album),
+		"-metadata", fmt.Sprintf("comment=%s", buildComment(t)),
+	}
+	if job.webm() {
+		// Matroska numbers tracks as parts of the album.
+		metadata = append(metadata,
+			"-metadata", fmt.Sprintf("PART_NUMBER=%d", t.Number),
+			"-metadata", fmt.Sprintf("TOTAL_PARTS=%d", len(job.Tracks)))
+	} else {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("track=%d/%d", t.Number, len(job.Tracks)))
+	}
+
+	if t.MainLabel != "" {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("publisher=%s", t.MainLabel))
+	}
+	if date := tagDate(job); date != "" {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("date=%s", date))
+	}
+	if job.Genre != "" {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("genre=%s", job.Genre))
+	}
+	if job.Disc != "" {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("disc=%s", job.Disc))
+	}
+	if job.Compilation {
+		metadata = append(metadata, "-metadata", "compilation=1")
+	}
+	if t.Credits.Version != "" {
+		metadata = append(metadata, "-metadata",
+			fmt.Sprintf("%s=%s", containerKey(job, "TIT3", "SUBTITLE"), t.Credits.Version))
+	}
+	if len(t.Credits.Remixers) > 0 {
+		metadata = append(metadata, "-metadata",
+			fmt.Sprintf("%s=%s", containerKey(job, "TPE4", "REMIXER"), strings.Join(t.Credits.Remixers, "; ")))
+	}
+
+	if job.embedLyrics() && t.Lyrics != "" {
+		metadata = append(metadata, "-metadata", fmt.Sprintf("lyrics=%s", t.Lyrics))
+	}
+
+	for _, tag := range job.Tags {
+		metadata = append(metadata, "-metadata", tag)
+	}
+
+	return metadata
+}
+
+func buildTitle(t *Track) string {
+	title := t.MainTitle
+	for _, add := range t.Additional {
+		title += " / " + add.Title
+	}
+	return title
+}
+
+func buildComment(t *Track) string {
+	var comments []string
+	for _, add := range t.Additional {
+		comments = append(comments, fmt.Sprintf("%s - %s [%s]",
+			add.Artist, add.Title, add.Label))
+	}
+	return "Additional tracks: " + strings.Join(comments, "; ")
+}
+
+// tagDate prefers --date, then --year, then a year mentioned in the album
+// name. Without any of them no date is written rather than a wrong one.
+func tagDate(job *Job) string {
+	switch {
+	case job.Date != "":
+		return job.Date
+	case job.Year != "":
+		return job.Year
+	default:
+		return albumYearRe.FindString(job.Album)
+	}
+}
+
+func albumArtist(t *Track, job *Job) string {
+	switch {
+	case job.AlbumArtist != "":
+		return job.AlbumArtist
+	case job.Compilation:
+		return "Various Artists"
+	default:
+		return t.Credits.Primary
+	}
+}
+
+// buildTagTitle is buildTitle for the title tag, which drops the version
+// suffix with --strip-version since it is written to its own tag.
+func buildTagTitle(t *Track, job *Job) string {
+	if !job.StripVersion {
+		return buildTitle(t)
+	}
+	title := t.Credits.BaseTitle
+	for _, add := range t.Additional {
+		title += " / " + add.Credits.BaseTitle
+	}
+	return title
+}
+
+// checkTagOptions checks the options setting tags, filling in the ID3
+// version the encoding implies.
+func checkTagOptions(o *Options) error {
+	if o.Disc != "" && !discRe.MatchString(o.Disc) {
+		return fmt.Errorf("invalid disc %q: want X or X/Y", o.Disc)
+	}
+	if o.Date != "" && !dateRe.MatchString(o.Date) {
+		return fmt.Errorf("invalid date %q: want YYYY, YYYY-MM or YYYY-MM-DD", o.Date)
+	}
+	if err := resolveID3(o); err != nil {
+		return err
+	}
+	if !validLyricsMode(o.Lyrics) {
+		return fmt.Errorf("invalid lyrics mode %q: want embed, lrc or both", o.Lyrics)
+	}
+	if o.Year != "" && !yearRe.MatchString(o.Year) {
+		return fmt.Errorf("invalid year %q", o.Year)
+	}
+	if !validDuplicatesMode(o.Duplicates) {
+		return fmt.Errorf("invalid duplicates mode %q: want merge or error", o.Duplicates)
+	}
+	return nil
+}
+
+// resolveID3 checks the ID3 flags and fills in the version implied by the
+// chosen encoding. ffmpeg writes non-ASCII text as UTF-16 in ID3v2.3 and as
+// UTF-8 in ID3v2.4, so the two settings cannot be picked independently.
+func resolveID3(o *Options) error {
+	switch o.ID3Encoding {
+	case "":
+	case "utf16":
+		if o.ID3Version == 4 {
+			return errors.New("UTF-16 tags require --id3-version 3")
+		}
+		o.ID3Version = 3
+	case "utf8":
+		if o.ID3Version == 3 {
+			return errors.New("UTF-8 tags require --id3-version 4")
+		}
+		o.ID3Version = 4
+	default:
+		return fmt.Errorf("invalid ID3 encoding %q: want utf8 or utf16", o.ID3Encoding)
+	}
+
+	if o.ID3Version != 0 && o.ID3Version != 3 && o.ID3Version != 4 {
+		return fmt.Errorf("invalid ID3 version %d: want 3 or 4", o.ID3Version)
+	}
+	return nil
+}
+
+func id3Args(job *Job) []string {
+	var args []string
+	if job.ID3Version != 0 {
+		args = append(args, "-id3v2_version", fmt.Sprint(job.ID3Version))
+	}
+	if job.ID3v1 {
+		args = append(args, "-write_id3v1", "1")
+	}
+	return args
+}
+
+// containerKey picks the tag name for the job's container: ffmpeg writes a
+// four-letter ID3 frame ID verbatim, while MP4 needs a freeform key.
+func containerKey(job *Job, id3Frame, freeform string) string {
+	if job.Ext == ".mp3" {
+		return id3Frame
+	}
+	return freeform
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nmlVersion is the collection format of Traktor Pro 3 and 4.
+const nmlVersion = "19"
+
+type traktorNML struct {
+	XMLName    xml.Name `xml:"NML"`
+	Version    string   `xml:"VERSION,attr"`
+	Head       traktorHead
+	Collection traktorCollection
+	Playlists  *traktorPlaylists `xml:",omitempty"`
+}
+
+type traktorHead struct {
+	XMLName xml.Name `xml:"HEAD"`
+	Company string   `xml:"COMPANY,attr"`
+	Program string   `xml:"PROGRAM,attr"`
+}
+
+type traktorCollection struct {
+	XMLName xml.Name       `xml:"COLLECTION"`
+	Entries int            `xml:"ENTRIES,attr"`
+	Tracks  []traktorEntry `xml:"ENTRY"`
+}
+
+type traktorEntry struct {
+	Title    string `xml:"TITLE,attr"`
+	Artist   string `xml:"ARTIST,attr"`
+	Location traktorLocation
+	Album    traktorAlbum
+	Info     traktorInfo
+	Cues     []traktorCue `xml:"CUE_V2"`
+}
+
+type traktorLocation struct {
+	XMLName xml.Name `xml:"LOCATION"`
+	Dir     string   `xml:"DIR,attr"`
+	File    string   `xml:"FILE,attr"`
+	Volume  string   `xml:"VOLUME,attr"`
+}
+
+type traktorAlbum struct {
+	XMLName xml.Name `xml:"ALBUM"`
+	Track   int      `xml:"TRACK,attr,omitempty"`
+	Title   string   `xml:"TITLE,attr"`
+}
+
+type traktorInfo struct {
+	XMLName  xml.Name `xml:"INFO"`
+	Genre    string   `xml:"GENRE,attr,omitempty"`
+	Label    string   `xml:"LABEL,attr,omitempty"`
+	Comment  string   `xml:"COMMENT,attr,omitempty"`
+	Playtime int      `xml:"PLAYTIME,attr"`
+	// PlaytimeFloat is the exact length in seconds.
+	PlaytimeFloat string `xml:"PLAYTIME_FLOAT,attr"`
+}
+
+type traktorCue struct {
+	Name string `xml:"NAME,attr"`
+	// Order is DISPL_ORDER, the cue's position in Traktor's cue list.
+	Order int `xml:"DISPL_ORDER,attr"`
+	// Type 0 is a plain cue point.
+	Type int `xml:"TYPE,attr"`
+	// Start is in milliseconds.
+	Start   string `xml:"START,attr"`
+	Len     string `xml:"LEN,attr"`
+	Repeats int    `xml:"REPEATS,attr"`
+	// Hotcue is the hot cue pad, or -1 for none.
+	Hotcue int `xml:"HOTCUE,attr"`
+}
+
+type traktorPlaylists struct {
+	XMLName xml.Name `xml:"PLAYLISTS"`
+	Root    traktorFolder
+}
+
+type traktorFolder struct {
+	XMLName  xml.Name `xml:"NODE"`
+	Type     string   `xml:"TYPE,attr"`
+	Name     string   `xml:"NAME,attr"`
+	Subnodes struct {
+		Count     int `xml:"COUNT,attr"`
+		Playlists []traktorPlaylistNode
+	} `xml:"SUBNODES"`
+}
+
+type traktorPlaylistNode struct {
+	XMLName  xml.Name `xml:"NODE"`
+	Type     string   `xml:"TYPE,attr"`
+	Name     string   `xml:"NAME,attr"`
+	Playlist struct {
+		Entries int    `xml:"ENTRIES,attr"`
+		Type    string `xml:"TYPE,attr"`
+		Entry   []traktorPlaylistEntry
+	} `xml:"PLAYLIST"`
+}
+
+// traktorPlaylistEntry refers to a collection entry by its location.
+type traktorPlaylistEntry struct {
+	XMLName    xml.Name `xml:"ENTRY"`
+	PrimaryKey struct {
+		Type string `xml:"TYPE,attr"`
+		Key  string `xml:"KEY,attr"`
+	} `xml:"PRIMARYKEY"`
+}
+
+// newTraktorLocation splits an absolute path the way Traktor stores it, with
+// every directory prefixed by "/:", as in DIR="/:Users/:me/:Music/:".
+func newTraktorLocation(path string) traktorLocation {
+	vol := filepath.VolumeName(path)
+	dir := strings.Trim(filepath.ToSlash(filepath.Dir(path[len(vol):])), "/")
+	loc := traktorLocation{File: filepath.Base(path), Volume: vol, Dir: "/:"}
+	if dir != "" {
+		loc.Dir = "/:" + strings.ReplaceAll(dir, "/", "/:") + "/:"
+	}
+	return loc
+}
+
+// key is the PRIMARYKEY playlists refer to collection entries by.
+func (l traktorLocation) key() string {
+	return l.Volume + l.Dir + l.File
+}
+
+// mixEntry is the full recording with a cue at every track, hot cues on the
+// first eight.
+func mixEntry(mix *cueMix) traktorEntry {
+	e := traktorEntry{
+		Title:    mix.Album,
+		Location: newTraktorLocation(mix.Path),
+		Album:    traktorAlbum{Title: mix.Album},
+		Info:     traktorInfo{Playtime: int(mix.Duration), PlaytimeFloat: fmt.Sprintf("%f", mix.Duration)},
+	}
+	for i := range mix.Tracks {
+		t := &mix.Tracks[i]
+		cue := traktorCue{Name: cueName(t), Order: i, Start: fmt.Sprintf("%f", t.StartTime*1000),
+			Len: "0.000000", Repeats: -1, Hotcue: -1}
+		if i < rekordboxHotCues {
+			cue.Hotcue = i
+		}
+		e.Cues = append(e.Cues, cue)
+	}
+	return e
+}
+
+// writeTraktorNML writes a Traktor collection holding just the recording
+// and its cues.
+func writeTraktorNML(w io.Writer, mix *cueMix) error {
+	return encodeNML(w, []traktorEntry{mixEntry(mix)}, nil)
+}
+
+// writeJobNML writes <album>.nml to the output directory: a collection of
+// every finished output with its tags and where it starts in the recording,
+// a playlist of them in order and, for a local input, the recording itself
+// with its cues. Traktor imports it from File > Import Collection.
+func writeJobNML(job *Job) (string, error) {
+	var entries []traktorEntry
+	playlist := traktorPlaylistNode{Type: "PLAYLIST", Name: job.Album}
+	playlist.Playlist.Type = "LIST"
+
+	states := job.States()
+	for i := range job.Tracks {
+		t := &job.Tracks[i]
+		if states[i].Status != StatusDone {
+			continue
+		}
+		path, err := filepath.Abs(t.OutputFilename)
+		if err != nil {
+			return "", err
+		}
+		_, length := job.clipRange(t)
+		e := traktorEntry{
+			Title:    buildTagTitle(t, job),
+			Artist:   t.MainArtist,
+			Location: newTraktorLocation(path),
+			Album:    traktorAlbum{Track: t.Number, Title: job.Album},
+			Info: traktorInfo{
+				Genre:         job.Genre,
+				Label:         t.MainLabel,
+				Comment:       fmt.Sprintf("Starts at %s in %s", formatTimestamp(t.StartTime), filepath.Base(job.Input)),
+				Playtime:      int(length),
+				PlaytimeFloat: fmt.Sprintf("%f", length),
+			},
+		}
+		entries = append(entries, e)
+
+		var ref traktorPlaylistEntry
+		ref.PrimaryKey.Type = "TRACK"
+		ref.PrimaryKey.Key = e.Location.key()
+		playlist.Playlist.Entry = append(playlist.Playlist.Entry, ref)
+	}
+	playlist.Playlist.Entries = len(playlist.Playlist.Entry)
+
+	if !isURL(job.Input) {
+		src, err := filepath.Abs(job.Input)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, mixEntry(&cueMix{Path: src, Album: job.Album, Duration: job.Duration, Tracks: job.Tracks}))
+	}
+
+	name := job.Filenames.Sanitize(job.Album)
+	if name == "" {
+		name = "output"
+	}
+	dest := filepath.Join(job.OutputDir, name+".nml")
+	err := writeFile(dest, func(f *os.File) error {
+		return encodeNML(f, entries, &playlist)
+	})
+	return dest, err
+}
+
+func encodeNML(w io.Writer, entries []traktorEntry, playlist *traktorPlaylistNode) error {
+	doc := traktorNML{
+		Version:    nmlVersion,
+		Head:       traktorHead{Company: "www.native-instruments.com", Program: "Traktor"},
+		Collection: traktorCollection{Entries: len(entries), Tracks: entries},
+	}
+	if playlist != nil {
+		doc.Playlists = &traktorPlaylists{Root: traktorFolder{Type: "FOLDER", Name: "$ROOT"}}
+		doc.Playlists.Root.Subnodes.Count = 1
+		doc.Playlists.Root.Subnodes.Playlists = []traktorPlaylistNode{*playlist}
+	}
+
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8" standalone="no" ?>`+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestNewTraktorLocation(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    traktorLocation
+		windows bool
+	}{
+		{path: "/Users/me/Music/mix.mp3", want: traktorLocation{Dir: "/:Users/:me/:Music/:", File: "mix.mp3"}},
+		{path: "/mix.mp3", want: traktorLocation{Dir: "/:", File: "mix.mp3"}},
+		{path: `C:\Music\Sets\mix.mp3`, want: traktorLocation{Volume: "C:", Dir: "/:Music/:Sets/:", File: "mix.mp3"}, windows: true},
+	}
+	for _, tt := range tests {
+		if tt.windows != (runtime.GOOS == "windows") {
+			continue
+		}
+		if got := newTraktorLocation(tt.path); got != tt.want {
+			t.Errorf("newTraktorLocation(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+	loc := newTraktorLocation("/Users/me/mix.mp3")
+	if got, want := loc.key(), "/:Users/:me/:mix.mp3"; got != want {
+		t.Errorf("key() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// notifySummary is the one-line outcome used for chat and desktop
+// notifications.
+func notifySummary(r *Report, cancelled bool) string {
+	switch {
+	case cancelled:
+		return fmt.Sprintf("%s: cancelled", r.Album)
+	case r.Failed > 0:
+		return fmt.Sprintf("%s: %d of %d tracks failed", r.Album, r.Failed, len(r.Tracks))
+	case r.Flagged > 0:
+		return fmt.Sprintf("%s: %d tracks split, %d flagged by verification", r.Album, len(r.Tracks), r.Flagged)
+	default:
+		return fmt.Sprintf("%s: %d tracks split", r.Album, len(r.Tracks))
+	}
+}
+
+// notifyWebhook posts the report to u. Discord and Slack webhooks only accept
+// their own message format, so they get the summary instead.
+func notifyWebhook(ctx context.Context, u string, r *Report, summary string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	var payload any = r
+	switch host := parsed.Hostname(); {
+	case host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com"):
+		payload = map[string]string{"content": summary}
+	case host == "hooks.slack.com":
+		payload = map[string]string{"text": summary}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("POST %s: %s: %s", parsed.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// notifyDesktop shows a desktop notification with notify-send on Linux and
+// the BSDs, osascript on macOS and a balloon tip on Windows.
+func notifyDesktop(ctx context.Context, summary string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", summary, "song-splitter")
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms
+$n = New-Object System.Windows.Forms.NotifyIcon
+$n.Icon = [System.Drawing.SystemIcons]::Information
+$n.Visible = $true
+$n.ShowBalloonTip(10000, 'song-splitter', '%s', 'Info')
+Start-Sleep -Seconds 10
+$n.Dispose()`, strings.ReplaceAll(summary, "'", "''"))
+		cmd = exec.CommandContext(ctx, "powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "song-splitter", summary)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s error: %v\nOutput: %s", cmd.Args[0], err, output)
+	}
+	return nil
+}
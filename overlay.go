@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Overlay positions for --overlay-position.
+const (
+	OverlayBottomLeft  = "bottom-left"
+	OverlayBottomRight = "bottom-right"
+	OverlayTopLeft     = "top-left"
+	OverlayTopRight    = "top-right"
+)
+
+// overlayMargin is the gap between the overlay and the frame's edges, as a
+// fraction of the frame height.
+const overlayMargin = 0.05
+
+// validateOverlay checks the --title-overlay options.
+func validateOverlay(opts Options) error {
+	if opts.TitleOverlay < 0 || opts.OverlayFade < 0 {
+		return fmt.Errorf("invalid title overlay duration")
+	}
+	if opts.TitleOverlay == 0 {
+		return nil
+	}
+	if !opts.Video {
+		return fmt.Errorf("--title-overlay requires --video")
+	}
+	if opts.VideoCopy {
+		return fmt.Errorf("--title-overlay cannot be combined with --video-copy, which does not re-encode the video")
+	}
+	switch opts.OverlayPosition {
+	case "", OverlayBottomLeft, OverlayBottomRight, OverlayTopLeft, OverlayTopRight:
+	default:
+		return fmt.Errorf("invalid overlay position %q: want bottom-left, bottom-right, top-left or top-right", opts.OverlayPosition)
+	}
+	if 2*opts.OverlayFade > opts.TitleOverlay {
+		return fmt.Errorf("--overlay-fade %s is too long for a %s overlay", opts.OverlayFade, opts.TitleOverlay)
+	}
+	return nil
+}
+
+// overlayText is what the overlay shows: the track, then a "w/" line for
+// each track played with it.
+func overlayText(t *Track) string {
+	lines := []string{t.MainArtist + " - " + t.MainTitle}
+	for _, add := range t.Additional {
+		lines = append(lines, "w/ "+add.Artist+" - "+add.Title)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// titleOverlayFilter draws the track's overlay for the first TitleOverlay of
+// the output, fading in and out over OverlayFade. offset is how far into
+// the filters' timeline the output starts, since the output seek trims
+// after them.
+func titleOverlayFilter(t *Track, job *Job, offset float64) string {
+	show := job.TitleOverlay.Seconds()
+	fade := job.OverlayFade.Seconds()
+	from, to := offset, offset+show
+
+	var x, y string
+	switch job.OverlayPosition {
+	case OverlayTopLeft:
+		x, y = "h*%[1]g", "h*%[1]g"
+	case OverlayTopRight:
+		x, y = "w-text_w-h*%[1]g", "h*%[1]g"
+	case OverlayBottomRight:
+		x, y = "w-text_w-h*%[1]g", "h-text_h-h*%[1]g"
+	default:
+		x, y = "h*%[1]g", "h-text_h-h*%[1]g"
+	}
+
+	opts := []string{
+		"text=" + escapeFilterValue(overlayText(t)),
+		"expansion=none",
+		"fontcolor=white",
+		"fontsize=h/24",
+		"line_spacing=h/120",
+		"box=1",
+		"boxcolor=black@0.5",
+		"boxborderw=h/90",
+		"x=" + fmt.Sprintf(x, overlayMargin),
+		"y=" + fmt.Sprintf(y, overlayMargin),
+		fmt.Sprintf("enable='between(t,%f,%f)'", from, to),
+	}
+	if fade > 0 {
+		opts = append(opts, fmt.Sprintf("alpha='min(1,min((t-%f)/%f,(%f-t)/%f))'", from, fade, to, fade))
+	}
+	if job.OverlayFont != "" {
+		opts = append(opts, "fontfile="+escapeFilterValue(job.OverlayFont))
+	}
+	return "drawtext=" + strings.Join(opts, ":")
+}
+
+// escapeFilterValue quotes s as a filter option value: once for the option
+// parser, which splits on colons, and once for the filtergraph parser, which
+// splits on commas and brackets.
+func escapeFilterValue(s string) string {
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(option)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{in: "/usr/share/fonts/DejaVuSans.ttf", want: "/usr/share/fonts/DejaVuSans.ttf"},
+		{in: `C:\Windows\Fonts\arial.ttf`, want: `C\\:\\\\Windows\\\\Fonts\\\\arial.ttf`},
+		{in: "it's", want: `it\\\'s`},
+		{in: "a,b;c[d]", want: `a\,b\;c\[d\]`},
+	}
+	for _, tt := range tests {
+		if got := escapeFilterValue(tt.in); got != tt.want {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
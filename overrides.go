@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrackOverride replaces fields of one parsed track. Unset fields are left
+// as parsed.
+type TrackOverride struct {
+	Title   *string       `json:"title"`
+	Artist  *string       `json:"artist"`
+	Label   *string       `json:"label"`
+	Start   *overrideTime `json:"start"`
+	End     *overrideTime `json:"end"`
+	Artwork *string       `json:"artwork"`
+	Gain    *overrideGain `json:"gain"`
+	Crop    *string       `json:"crop"`
+	Rotate  *int          `json:"rotate"`
+	Scale   *string       `json:"scale"`
+	// MaxLength and Gap replace --max-track-length and --track-gap.
+	MaxLength *overrideTime `json:"max_length"`
+	Gap       *overrideTime `json:"gap"`
+}
+
+// overrideTime accepts either seconds or a "[H:]MM:SS" timestamp.
+type overrideTime float64
+
+func (t *overrideTime) UnmarshalJSON(data []byte) error {
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err == nil {
+		*t = overrideTime(secs)
+		return nil
+	}
+	var ts string
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return fmt.Errorf("time must be seconds or a timestamp string")
+	}
+	secs, err := parseTimestamp(ts)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", ts)
+	}
+	*t = overrideTime(secs)
+	return nil
+}
+
+// overrideGain accepts either decibels or a string such as "+3.5dB".
+type overrideGain float64
+
+var gainRe = regexp.MustCompile(`(?i)^\s*([+-]?\d+(?:\.\d+)?)\s*(?:db)?\s*$`)
+
+func (g *overrideGain) UnmarshalJSON(data []byte) error {
+	var db float64
+	if err := json.Unmarshal(data, &db); err == nil {
+		*g = overrideGain(db)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("gain must be decibels or a string such as \"+3.5dB\"")
+	}
+	m := gainRe.FindStringSubmatch(s)
+	if m == nil {
+		return fmt.Errorf("invalid gain %q", s)
+	}
+	db, _ = strconv.ParseFloat(m[1], 64)
+	*g = overrideGain(db)
+	return nil
+}
+
+// loadOverrides reads a JSON object whose keys are 1-based track numbers or
+// track titles (matched case-insensitively). Artwork paths are resolved
+// against the file's directory.
+func loadOverrides(path string) (map[string]TrackOverride, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides map[string]TrackOverride
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("parse overrides: %v", err)
+	}
+
+	base := filepath.Dir(path)
+	for key, o := range overrides {
+		if o.Artwork != nil && *o.Artwork != "" {
+			artwork := resolvePath(base, *o.Artwork)
+			o.Artwork = &artwork
+			overrides[key] = o
+		}
+	}
+	return overrides, nil
+}
+
+// applyOverrides edits tracks in place and re-sorts them if a start time
+// moved. Every key must match a track so typos do not go unnoticed. Track
+// numbers are those the outputs will have, which numbered maps to indexes
+// into tracks (see numberedTracks).
+func applyOverrides(tracks []Track, numbered []int, overrides map[string]TrackOverride) error {
+	// Resolve every key before editing so a renamed title cannot change
+	// what another key matches.
+	targets := make(map[string]int, len(overrides))
+	for key := range overrides {
+		i, err := overrideTarget(tracks, numbered, key)
+		if err != nil {
+			return err
+		}
+		targets[key] = i
+	}
+
+	for key, o := range overrides {
+		t := &tracks[targets[key]]
+
+		if o.Title != nil {
+			t.MainTitle = *o.Title
+		}
+		if o.Artist != nil {
+			t.MainArtist = *o.Artist
+		}
+		if o.Label != nil {
+			t.MainLabel = *o.Label
+		}
+		if o.Start != nil {
+			t.StartTime = float64(*o.Start)
+			t.Estimated, t.Detected = false, false
+		}
+		if o.End != nil {
+			t.EndTime = float64(*o.End)
+		}
+		if o.Artwork != nil {
+			if _, err := os.Stat(*o.Artwork); err != nil {
+				return fmt.Errorf("override %q: %v", key, err)
+			}
+			t.Artwork = *o.Artwork
+		}
+		if o.Gain != nil {
+			t.Gain = float64(*o.Gain)
+		}
+		if o.Crop != nil {
+			t.Transform.Crop = *o.Crop
+		}
+		if o.Rotate != nil {
+			t.Transform.Rotate = *o.Rotate
+		}
+		if o.Scale != nil {
+			t.Transform.Scale = *o.Scale
+		}
+		if err := t.Transform.validate(); err != nil {
+			return fmt.Errorf("override %q: %v", key, err)
+		}
+		if o.MaxLength != nil {
+			t.Ends.MaxLength = time.Duration(float64(*o.MaxLength) * float64(time.Second))
+		}
+		if o.Gap != nil {
+			t.Ends.Gap = time.Duration(float64(*o.Gap) * float64(time.Second))
+		}
+		if err := t.Ends.validate(); err != nil {
+			return fmt.Errorf("override %q: %v", key, err)
+		}
+		if o.Title != nil || o.Artist != nil {
+			t.Credits = parseCredits(t.MainArtist, t.MainTitle)
+		}
+	}
+
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].StartTime < tracks[j].StartTime })
+	return nil
+}
+
+func overrideTarget(tracks []Track, numbered []int, key string) (int, error) {
+	if n, err := strconv.Atoi(key); err == nil {
+		if n < 1 || n > len(numbered) {
+			return 0, fmt.Errorf("override %q: tracklist has %d tracks", key, len(numbered))
+		}
+		return numbered[n-1], nil
+	}
+
+	match := -1
+	for i, t := range tracks {
+		if strings.EqualFold(t.MainTitle, key) {
+			if match >= 0 {
+				return 0, fmt.Errorf("override %q matches several tracks; use the track number", key)
+			}
+			match = i
+		}
+	}
+	if match < 0 {
+		return 0, fmt.Errorf("override %q matches no track", key)
+	}
+	return match, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestApplyOverridesNumbering(t *testing.T) {
+	title := func(s string) *string { return &s }
+	tracklist := func() []Track {
+		return []Track{
+			{Line: 1, StartTime: 0, MainTitle: "One"},
+			{Line: 2, StartTime: 0, MainTitle: "One w/"},
+			{Line: 3, StartTime: 100, Segment: "MC", MainTitle: "MC"},
+			{Line: 4, StartTime: 120, MainTitle: "Two"},
+			{Line: 5, StartTime: 200, MainTitle: "Three"},
+		}
+	}
+	tests := []struct {
+		name                 string
+		segments, duplicates string
+		key                  string
+		wantLine             int
+	}{
+		{name: "after a merged duplicate", segments: SegmentsExclude, duplicates: DuplicatesMerge, key: "2", wantLine: 4},
+		{name: "default duplicates mode", segments: SegmentsExclude, key: "3", wantLine: 5},
+		{name: "duplicates kept", segments: SegmentsExclude, duplicates: DuplicatesError, key: "2", wantLine: 2},
+		{name: "bonus segment counted", segments: SegmentsBonus, duplicates: DuplicatesMerge, key: "3", wantLine: 4},
+		{name: "by title", segments: SegmentsExclude, duplicates: DuplicatesMerge, key: "one w/", wantLine: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := applySegments(tracklist(), tt.segments)
+			numbered := numberedTracks(tracks, tt.segments, tt.duplicates)
+			if err := applyOverrides(tracks, numbered, map[string]TrackOverride{tt.key: {Title: title("Fixed")}}); err != nil {
+				t.Fatal(err)
+			}
+			for _, tr := range tracks {
+				if (tr.MainTitle == "Fixed") != (tr.Line == tt.wantLine) {
+					t.Errorf("line %d has title %q; want only line %d retitled", tr.Line, tr.MainTitle, tt.wantLine)
+				}
+			}
+		})
+	}
+
+	tracks := applySegments(tracklist(), SegmentsExclude)
+	numbered := numberedTracks(tracks, SegmentsExclude, DuplicatesMerge)
+	if err := applyOverrides(tracks, numbered, map[string]TrackOverride{"4": {Title: title("Fixed")}}); err == nil {
+		t.Error("override of track 4 of 3 succeeded")
+	}
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setPriority runs cmd under nice, and for the idle priority under ionice's
+// idle class where it is installed, as on most Linux systems. Both exec the
+// command in place, so cancelling still reaches it.
+func setPriority(cmd *exec.Cmd, priority string) {
+	var prefix []string
+	switch priority {
+	case PriorityLow:
+		prefix = []string{"nice", "-n", "10"}
+	case PriorityIdle:
+		prefix = []string{"nice", "-n", "19"}
+		if _, err := exec.LookPath("ionice"); err == nil {
+			prefix = append([]string{"ionice", "-c", "3"}, prefix...)
+		}
+	default:
+		return
+	}
+	if _, err := exec.LookPath("nice"); err != nil {
+		// Without nice the command runs at normal priority.
+		return
+	}
+	path, err := exec.LookPath(prefix[0])
+	if err != nil {
+		return
+	}
+	cmd.Path = path
+	cmd.Args = append(prefix, cmd.Args...)
+}
+
+// limitMemory runs cmd under a shell that first lowers its address space
+// limit (ulimit -v, in KiB), which the command inherits.
+func limitMemory(cmd *exec.Cmd, bytes int64) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return
+	}
+	script := fmt.Sprintf(`ulimit -v %d && exec "$0" "$@"`, bytes/1024)
+	cmd.Args = append([]string{"sh", "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sh
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const (
+	belowNormalPriorityClass = 0x00004000
+	idlePriorityClass        = 0x00000040
+)
+
+// limitMemory is a no-op; --memory-limit is rejected on Windows.
+func limitMemory(cmd *exec.Cmd, bytes int64) {}
+
+// setPriority starts cmd in the process priority class for priority.
+func setPriority(cmd *exec.Cmd, priority string) {
+	switch priority {
+	case PriorityLow:
+		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: belowNormalPriorityClass}
+	case PriorityIdle:
+		cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: idlePriorityClass}
+	}
+}
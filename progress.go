@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+const (
+	// progressScale is the resolution of a worker's bar.
+	progressScale = 1000
+	// progressNameWidth is the width the track names of worker bars are
+	// padded or cut to, so their bars line up.
+	progressNameWidth = 32
+)
+
+const (
+	totalBarTemplate  = `{{string . "prefix"}} {{bar . }} {{percent . }} {{rtime . "ETA %s"}}`
+	workerBarTemplate = `{{string . "prefix"}} {{bar . }} {{percent . }}`
+	idleBarTemplate   = `{{string . "prefix"}}`
+)
+
+// progressDisplay shows a bar per worker, with the track it is on and how
+// much of it is encoded, under a bar for the whole run with its ETA. The
+// whole run is measured in seconds of tracks, so a long track counts for
+// more than a short one. Without a terminal to redraw it falls back to the
+// overall bar alone.
+type progressDisplay struct {
+	pool  *pb.Pool
+	total *pb.ProgressBar
+
+	mu      sync.Mutex
+	tracks  int
+	done    int
+	workers []*pb.ProgressBar
+	// busy maps the running tracks of the current job to their workers'
+	// bars, and weight is the share of the total each track of it counts
+	// for.
+	busy     map[int]*pb.ProgressBar
+	progress map[int]float64
+	weight   []int64
+	// finished is the total of the tracks done, in milliseconds.
+	finished int64
+}
+
+func startProgress(jobs []*Job) *progressDisplay {
+	d := &progressDisplay{busy: make(map[int]*pb.ProgressBar), progress: make(map[int]float64)}
+	var total int64
+	for _, job := range jobs {
+		d.tracks += len(job.Tracks)
+		for _, w := range progressWeights(job) {
+			total += w
+		}
+	}
+	d.total = pb.New64(max(total, 1)).SetTemplateString(totalBarTemplate)
+
+	for range maxWorkers {
+		d.workers = append(d.workers, pb.New(progressScale).SetTemplateString(idleBarTemplate).Set("prefix", "  idle"))
+	}
+	pool, err := pb.StartPool(append(d.workers, d.total)...)
+	if err != nil {
+		// Not a terminal: redrawing several lines would only garble it.
+		d.workers = nil
+		d.setTotalPrefix()
+		d.total.Start()
+		return d
+	}
+	d.pool = pool
+	d.setTotalPrefix()
+	return d
+}
+
+// progressWeights is how much each track of job counts towards the whole
+// run: its length in milliseconds, or nothing if another job's commands
+// encode it.
+func progressWeights(job *Job) []int64 {
+	weights := make([]int64, len(job.Tracks))
+	if job.encodedBy != nil {
+		return weights
+	}
+	for i := range job.Tracks {
+		_, length := job.clipRange(&job.Tracks[i])
+		weights[i] = int64(length * 1000)
+	}
+	return weights
+}
+
+// watch makes the display follow job's tracks; jobs run one at a time.
+func (d *progressDisplay) watch(job *Job) {
+	d.mu.Lock()
+	d.weight = progressWeights(job)
+	clear(d.busy)
+	clear(d.progress)
+	d.mu.Unlock()
+
+	job.OnUpdate = func(i int, st TrackState) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		switch st.Status {
+		case StatusRunning:
+			d.progress[i] = st.Progress
+			if bar, ok := d.busy[i]; ok {
+				bar.SetCurrent(int64(st.Progress * progressScale))
+			} else if bar := d.idleWorker(); bar != nil {
+				d.busy[i] = bar
+				bar.SetTemplateString(workerBarTemplate).Set("prefix", progressName(&job.Tracks[i])).SetCurrent(0)
+			}
+		case StatusDone, StatusFailed:
+			if bar, ok := d.busy[i]; ok {
+				bar.SetTemplateString(idleBarTemplate).Set("prefix", "  idle")
+				delete(d.busy, i)
+			}
+			delete(d.progress, i)
+			d.finished += d.weight[i]
+			d.done++
+			d.setTotalPrefix()
+		}
+
+		current := d.finished
+		for i, p := range d.progress {
+			current += int64(p * float64(d.weight[i]))
+		}
+		d.total.SetCurrent(current)
+	}
+}
+
+func (d *progressDisplay) idleWorker() *pb.ProgressBar {
+	for _, bar := range d.workers {
+		busy := false
+		for _, b := range d.busy {
+			busy = busy || b == bar
+		}
+		if !busy {
+			return bar
+		}
+	}
+	return nil
+}
+
+func (d *progressDisplay) setTotalPrefix() {
+	prefix := fmt.Sprintf("Total: %d/%d tracks", d.done, d.tracks)
+	if d.workers != nil {
+		// Line the bar up with the workers'.
+		prefix = fmt.Sprintf("%-*s", progressNameWidth+2, prefix)
+	}
+	d.total.Set("prefix", prefix)
+}
+
+// progressName is the label of a worker's bar: the track, cut or padded to
+// progressNameWidth.
+func progressName(t *Track) string {
+	name := []rune(fmt.Sprintf("%02d %s - %s", t.Number, t.MainArtist, t.MainTitle))
+	if len(name) > progressNameWidth {
+		name = append(name[:progressNameWidth-1], '…')
+	}
+	return fmt.Sprintf("  %-*s", progressNameWidth, string(name))
+}
+
+func (d *progressDisplay) finish() {
+	d.total.Finish()
+	if d.pool != nil {
+		for _, bar := range d.workers {
+			bar.Finish()
+		}
+		d.pool.Stop()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import "time"
+
+// Report summarises a job's plan and the outcome of every track.
+type Report struct {
+	Album string `json:"album"`
+	Input string `json:"input,omitempty"`
+	// Parts are the files of an input given in several.
+	Parts []string `json:"parts,omitempty"`
+	// InputSHA256 is set with --checksums for local inputs.
+	InputSHA256 string     `json:"input_sha256,omitempty"`
+	Format      string     `json:"format"`
+	Duration    float64    `json:"duration"`
+	Started     *time.Time `json:"started,omitempty"`
+	Finished    *time.Time `json:"finished,omitempty"`
+	Failed      int        `json:"failed"`
+	// Flagged counts tracks failing --verify.
+	Flagged int           `json:"flagged"`
+	Tracks  []TrackReport `json:"tracks"`
+}
+
+type TrackReport struct {
+	Index  int     `json:"index"`
+	Artist string  `json:"artist"`
+	Title  string  `json:"title"`
+	Label  string  `json:"label,omitempty"`
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Drift  float64 `json:"drift,omitempty"`
+	Output string  `json:"output,omitempty"`
+	// Stems are the outputs of --stems.
+	Stems  []string    `json:"stems,omitempty"`
+	Status TrackStatus `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	// Problems are what --verify found wrong with the output.
+	Problems []string `json:"problems,omitempty"`
+	SHA256   string   `json:"sha256,omitempty"`
+	// Loudness is set with --analyze.
+	Loudness *Loudness `json:"loudness,omitempty"`
+}
+
+func (j *Job) Report() *Report {
+	r := &Report{
+		Album:    j.Album,
+		Input:    j.Input,
+		Parts:    j.InputParts,
+		Format:   "audio",
+		Duration: j.Duration,
+	}
+	if len(j.InputParts) > 0 {
+		// The playlist is gone once the run ends.
+		r.Input = ""
+	}
+	if j.Video {
+		r.Format = "video"
+	} else if !j.encodes() {
+		r.Format = "analysis"
+	}
+
+	j.mu.Lock()
+	if !j.started.IsZero() {
+		started := j.started
+		r.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		r.Finished = &finished
+	}
+	r.InputSHA256 = j.sourceSum
+	sums := j.sums
+	j.mu.Unlock()
+
+	for i, st := range j.States() {
+		t := &j.Tracks[i]
+		if st.Status == StatusFailed {
+			r.Failed++
+		}
+		p := j.Problems(i)
+		if len(p) > 0 {
+			r.Flagged++
+		}
+		r.Tracks = append(r.Tracks, TrackReport{
+			Index:    i + 1,
+			Artist:   t.MainArtist,
+			Title:    buildTitle(t),
+			Label:    t.MainLabel,
+			Start:    t.StartTime,
+			End:      t.EndTime,
+			Drift:    t.Drift,
+			Output:   t.OutputFilename,
+			Stems:    j.StemFiles(i),
+			Status:   st.Status,
+			Error:    st.Err,
+			Problems: p,
+			Loudness: j.Loudness(i),
+		})
+		if !j.encodes() {
+			r.Tracks[len(r.Tracks)-1].Output = ""
+		}
+		if i < len(sums) {
+			r.Tracks[len(r.Tracks)-1].SHA256 = sums[i]
+		}
+	}
+	return r
+}
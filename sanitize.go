@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	FilenamesDefault = "default"
+	FilenamesWindows = "windows"
+	FilenamesASCII   = "ascii"
+)
+
+// unsafeFilenameChars are the characters dropped (or replaced) in every mode.
+const unsafeFilenameChars = `<>:"/\|?*`
+
+// windowsReserved are device names Windows refuses as a base name, with or
+// without an extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// FilenamePolicy controls how artist and title text becomes a filename.
+type FilenamePolicy struct {
+	// Mode is "default" (drop unsafe characters), "windows" (also handle
+	// reserved names, control characters and trailing dots/spaces) or
+	// "ascii" (windows plus transliteration to ASCII). Empty means
+	// "windows" on Windows and "default" elsewhere.
+	Mode string
+	// Replacement is substituted for each unsafe character instead of
+	// dropping it.
+	Replacement string
+	// MaxLength caps the length of a whole filename in characters,
+	// extension included. Zero means no limit.
+	MaxLength int
+}
+
+func (p FilenamePolicy) validate() error {
+	switch p.Mode {
+	case "", FilenamesDefault, FilenamesWindows, FilenamesASCII:
+	default:
+		return fmt.Errorf("invalid filename mode %q: want default, windows or ascii", p.Mode)
+	}
+	if strings.ContainsAny(p.Replacement, unsafeFilenameChars) {
+		return fmt.Errorf("filename replacement %q contains unsafe characters", p.Replacement)
+	}
+	if p.MaxLength < 0 {
+		return fmt.Errorf("invalid filename max length %d", p.MaxLength)
+	}
+	return nil
+}
+
+func (p FilenamePolicy) strict() bool {
+	mode := p.Mode
+	if mode == "" {
+		mode = platformFilenames()
+	}
+	return mode == FilenamesWindows || mode == FilenamesASCII
+}
+
+// platformFilenames is the filename mode when none is chosen: Windows-safe
+// on Windows, where names the default mode allows cannot be created.
+func platformFilenames() string {
+	if runtime.GOOS == "windows" {
+		return FilenamesWindows
+	}
+	return FilenamesDefault
+}
+
+// Sanitize makes a single path component safe under the policy. It does not
+// truncate; see Filename.
+func (p FilenamePolicy) Sanitize(name string) string {
+	return p.reserved(p.clean(name))
+}
+
+// clean replaces or drops unsafe characters and, in the strict modes,
+// trailing dots and spaces.
+func (p FilenamePolicy) clean(name string) string {
+	if p.Mode == FilenamesASCII {
+		name = transliterate(name, p.Replacement)
+	}
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case strings.ContainsRune(unsafeFilenameChars, r):
+			b.WriteString(p.Replacement)
+		case p.strict() && (r < 0x20 || r == 0x7f):
+			b.WriteString(p.Replacement)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name = b.String()
+
+	if p.strict() {
+		name = strings.TrimRight(name, ". ")
+	}
+	return name
+}
+
+// reserved prefixes names Windows would treat as a device in the strict
+// modes.
+func (p FilenamePolicy) reserved(name string) string {
+	base, _, _ := strings.Cut(name, ".")
+	if p.strict() && windowsReserved[strings.ToUpper(strings.TrimSpace(base))] {
+		return "_" + name
+	}
+	return name
+}
+
+// Filename joins sanitized parts with " - ", substituting "Unknown" for parts
+// that sanitize to nothing, and truncates the result to MaxLength while
+// keeping ext.
+func (p FilenamePolicy) Filename(ext string, parts ...string) string {
+	clean := make([]string, len(parts))
+	for i, part := range parts {
+		clean[i] = strings.TrimSpace(p.clean(part))
+		if clean[i] == "" {
+			clean[i] = "Unknown"
+		}
+	}
+	stem := strings.Join(clean, " - ")
+
+	if p.MaxLength > 0 {
+		limit := p.MaxLength - utf8.RuneCountInString(ext)
+		if limit < 1 {
+			limit = 1
+		}
+		if utf8.RuneCountInString(stem) > limit {
+			stem = string([]rune(stem)[:limit])
+			stem = strings.TrimRight(stem, " -")
+			if p.strict() {
+				stem = strings.TrimRight(stem, ". ")
+			}
+		}
+	}
+	return p.reserved(stem) + ext
+}
+
+// uniquePaths appends " (2)", " (3)"… to paths that collide, comparing case
+// insensitively since macOS and Windows filesystems do.
+func uniquePaths(paths []string) {
+	seen := make(map[string]bool, len(paths))
+	for i, path := range paths {
+		ext := filepath.Ext(path)
+		stem := strings.TrimSuffix(path, ext)
+		candidate := path
+		for n := 2; seen[strings.ToLower(candidate)]; n++ {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		}
+		seen[strings.ToLower(candidate)] = true
+		paths[i] = candidate
+	}
+}
+
+func sanitizeFilename(name string) string {
+	return FilenamePolicy{}.Sanitize(name)
+}
+
+// transliterate maps Latin diacritics, Greek and Cyrillic to ASCII and
+// replaces anything else outside ASCII with repl.
+func transliterate(s, repl string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case hasFold(r):
+			b.WriteString(asciiFold[r])
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		case unicode.Is(unicode.Mn, r):
+			// Combining marks: the base letter has already been written.
+		default:
+			b.WriteString(repl)
+		}
+	}
+	return b.String()
+}
+
+var asciiFold = buildASCIIFold()
+
+func hasFold(r rune) bool {
+	_, ok := asciiFold[r]
+	return ok
+}
+
+func buildASCIIFold() map[rune]string {
+	m := make(map[rune]string)
+	groups := map[string]string{
+		"a": "àáâãäåāăą", "A": "ÀÁÂÃÄÅĀĂĄ",
+		"c": "çćĉċč", "C": "ÇĆĈĊČ",
+		"d": "ďđ", "D": "ĎĐ",
+		"e": "èéêëēĕėęě", "E": "ÈÉÊËĒĔĖĘĚ",
+		"g": "ĝğġģ", "G": "ĜĞĠĢ",
+		"h": "ĥħ", "H": "ĤĦ",
+		"i": "ìíîïĩīĭįı", "I": "ÌÍÎÏĨĪĬĮİ",
+		"j": "ĵ", "J": "Ĵ",
+		"k": "ķ", "K": "Ķ",
+		"l": "ĺļľŀł", "L": "ĹĻĽĿŁ",
+		"n": "ñńņňŉ", "N": "ÑŃŅŇ",
+		"o": "òóôõöøōŏő", "O": "ÒÓÔÕÖØŌŎŐ",
+		"r": "ŕŗř", "R": "ŔŖŘ",
+		"s": "śŝşšș", "S": "ŚŜŞŠȘ",
+		"t": "ţťŧț", "T": "ŢŤŦȚ",
+		"u": "ùúûüũūŭůűų", "U": "ÙÚÛÜŨŪŬŮŰŲ",
+		"w": "ŵ", "W": "Ŵ",
+		"y": "ýÿŷ", "Y": "ÝŸŶ",
+		"z": "źżž", "Z": "ŹŻŽ",
+	}
+	for ascii, letters := range groups {
+		for _, r := range letters {
+			m[r] = ascii
+		}
+	}
+
+	pairs := []string{
+		"ß", "ss", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE", "þ", "th", "Þ", "Th", "ð", "d", "Ð", "D",
+		"‘", "'", "’", "'", "“", `'`, "”", `'`, "–", "-", "—", "-", "…", "...", "×", "x",
+		// Greek
+		"α", "a", "β", "v", "γ", "g", "δ", "d", "ε", "e", "ζ", "z", "η", "i", "θ", "th",
+		"ι", "i", "κ", "k", "λ", "l", "μ", "m", "ν", "n", "ξ", "x", "ο", "o", "π", "p",
+		"ρ", "r", "σ", "s", "ς", "s", "τ", "t", "υ", "y", "φ", "f", "χ", "ch", "ψ", "ps", "ω", "o",
+		"ά", "a", "έ", "e", "ή", "i", "ί", "i", "ό", "o", "ύ", "y", "ώ", "o",
+		"Α", "A", "Β", "V", "Γ", "G", "Δ", "D", "Ε", "E", "Ζ", "Z", "Η", "I", "Θ", "Th",
+		"Ι", "I", "Κ", "K", "Λ", "L", "Μ", "M", "Ν", "N", "Ξ", "X", "Ο", "O", "Π", "P",
+		"Ρ", "R", "Σ", "S", "Τ", "T", "Υ", "Y", "Φ", "F", "Χ", "Ch", "Ψ", "Ps", "Ω", "O",
+		// Cyrillic
+		"а", "a", "б", "b", "в", "v", "г", "g", "д", "d", "е", "e", "ё", "yo", "ж", "zh",
+		"з", "z", "и", "i", "й", "y", "к", "k", "л", "l", "м", "m", "н", "n", "о", "o",
+		"п", "p", "р", "r", "с", "s", "т", "t", "у", "u", "ф", "f", "х", "kh", "ц", "ts",
+		"ч", "ch", "ш", "sh", "щ", "shch", "ъ", "", "ы", "y", "ь", "", "э", "e", "ю", "yu", "я", "ya",
+		"і", "i", "ї", "yi", "є", "ye", "ґ", "g",
+		"А", "A", "Б", "B", "В", "V", "Г", "G", "Д", "D", "Е", "E", "Ё", "Yo", "Ж", "Zh",
+		"З", "Z", "И", "I", "Й", "Y", "К", "K", "Л", "L", "М", "M", "Н", "N", "О", "O",
+		"П", "P", "Р", "R", "С", "S", "Т", "T", "У", "U", "Ф", "F", "Х", "Kh", "Ц", "Ts",
+		"Ч", "Ch", "Ш", "Sh", "Щ", "Shch", "Ъ", "", "Ы", "Y", "Ь", "", "Э", "E", "Ю", "Yu", "Я", "Ya",
+		"І", "I", "Ї", "Yi", "Є", "Ye", "Ґ", "G",
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		r, _ := utf8.DecodeRuneInString(pairs[i])
+		m[r] = pairs[i+1]
+	}
+	return m
+}
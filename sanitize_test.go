@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFilenamePolicyFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy FilenamePolicy
+		parts  []string
+		want   string
+	}{
+		{name: "plain", policy: FilenamePolicy{Mode: FilenamesDefault}, parts: []string{"01", "Artist", "Title"}, want: "01 - Artist - Title.mp3"},
+		{name: "unsafe characters dropped", policy: FilenamePolicy{Mode: FilenamesDefault}, parts: []string{"AC/DC", "What?"}, want: "ACDC - What.mp3"},
+		{name: "unsafe characters replaced", policy: FilenamePolicy{Mode: FilenamesDefault, Replacement: "_"}, parts: []string{"AC/DC", "a:b"}, want: "AC_DC - a_b.mp3"},
+		{name: "empty part", policy: FilenamePolicy{Mode: FilenamesDefault}, parts: []string{"01", "???", "Title"}, want: "01 - Unknown - Title.mp3"},
+		{name: "trailing dots kept by default", policy: FilenamePolicy{Mode: FilenamesDefault}, parts: []string{"Title..."}, want: "Title....mp3"},
+		{name: "trailing dots dropped on windows", policy: FilenamePolicy{Mode: FilenamesWindows}, parts: []string{"Title. . "}, want: "Title.mp3"},
+		{name: "reserved name", policy: FilenamePolicy{Mode: FilenamesWindows}, parts: []string{"con"}, want: "_con.mp3"},
+		{name: "reserved name allowed by default", policy: FilenamePolicy{Mode: FilenamesDefault}, parts: []string{"con"}, want: "con.mp3"},
+		{name: "control characters on windows", policy: FilenamePolicy{Mode: FilenamesWindows}, parts: []string{"a\tb"}, want: "ab.mp3"},
+		{name: "transliterated", policy: FilenamePolicy{Mode: FilenamesASCII}, parts: []string{"Beyoncé", "Ça va"}, want: "Beyonce - Ca va.mp3"},
+		{name: "truncated keeping the extension", policy: FilenamePolicy{Mode: FilenamesDefault, MaxLength: 14}, parts: []string{"01", "Artist", "Title"}, want: "01 - Artis.mp3"},
+		{name: "truncated without a dangling separator", policy: FilenamePolicy{Mode: FilenamesDefault, MaxLength: 13}, parts: []string{"01", "Artist"}, want: "01 - Arti.mp3"},
+		{name: "truncated at a separator", policy: FilenamePolicy{Mode: FilenamesDefault, MaxLength: 9}, parts: []string{"01", "Artist"}, want: "01.mp3"},
+		{name: "truncated by characters", policy: FilenamePolicy{Mode: FilenamesDefault, MaxLength: 7}, parts: []string{"ééééé"}, want: "ééé.mp3"},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Filename(".mp3", tt.parts...); got != tt.want {
+			t.Errorf("%s: Filename(%q) = %q, want %q", tt.name, tt.parts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Process priorities for --priority.
+const (
+	PriorityNormal = "normal"
+	// PriorityLow is nice 10, or the below-normal class on Windows.
+	PriorityLow = "low"
+	// PriorityIdle is nice 19 with idle I/O scheduling on Linux, or the idle
+	// class on Windows: encoders only get what nothing else wants.
+	PriorityIdle = "idle"
+)
+
+// loadCheckInterval is how often a waiting encoder checks the load again.
+const loadCheckInterval = 5 * time.Second
+
+// scheduler admits every ffmpeg process of the program, across all jobs, so
+// that no more than maxWorkers run at once. Its priority and memory limit
+// apply to the processes execExecutor starts.
+type scheduler struct {
+	slots chan struct{}
+	// Priority is a Priority* constant.
+	Priority string
+	// MaxLoad holds back new processes while the 1-minute load average is
+	// above it; 0 means no limit.
+	MaxLoad float64
+	// MemoryLimit caps the address space of each process, in bytes; 0
+	// means no limit.
+	MemoryLimit byteSize
+	// alone is held while a process takes every slot, so that two of them
+	// cannot each hold some and wait forever for the rest.
+	alone sync.Mutex
+}
+
+var procs = &scheduler{slots: make(chan struct{}, maxWorkers), Priority: PriorityNormal}
+
+// bindSchedulerFlags registers the process flags, which apply to the whole
+// program rather than to a job.
+func bindSchedulerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&procs.Priority, "priority", PriorityNormal, "CPU and I/O priority of ffmpeg: normal, low or idle, to keep a desktop usable while splitting")
+	fs.Float64Var(&procs.MaxLoad, "max-load", 0, "Hold back new ffmpeg processes while the 1-minute load average is above this (Linux only)")
+	fs.Var(&procs.MemoryLimit, "memory-limit", "Cap each ffmpeg process's memory, e.g. 2G; tracks that run out are retried alone with fewer threads (not on Windows)")
+}
+
+func (s *scheduler) validate() error {
+	switch s.Priority {
+	case PriorityNormal, PriorityLow, PriorityIdle:
+	default:
+		return fmt.Errorf("invalid priority %q: want normal, low or idle", s.Priority)
+	}
+	if s.MaxLoad < 0 {
+		return errors.New("--max-load must not be negative")
+	}
+	if s.MaxLoad > 0 {
+		if _, err := loadAverage(); err != nil {
+			return fmt.Errorf("--max-load: %v", err)
+		}
+	}
+	if s.MemoryLimit > 0 && runtime.GOOS == "windows" {
+		return errors.New("--memory-limit is not supported on Windows")
+	}
+	return nil
+}
+
+// runFFmpeg waits for a process slot and for the load to allow another
+// encoder, then runs c with the executor.
+func runFFmpeg(ctx context.Context, c *Command) error {
+	done, err := procs.acquire(ctx, 1)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return executor.Run(ctx, c)
+}
+
+// runFFmpegAlone is runFFmpeg for a process that must have the machine to
+// itself, such as a retry after running out of memory. It waits for every
+// other process to finish and holds back new ones.
+func runFFmpegAlone(ctx context.Context, c *Command) error {
+	done, err := procs.acquireAll(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+	return executor.Run(ctx, c)
+}
+
+// acquireAll takes every process slot, once the processes holding them are
+// done.
+func (s *scheduler) acquireAll(ctx context.Context) (done func(), err error) {
+	s.alone.Lock()
+	defer s.alone.Unlock()
+	return s.acquire(ctx, cap(s.slots))
+}
+
+// acquire takes slots process slots once the load allows; done gives them
+// back.
+func (s *scheduler) acquire(ctx context.Context, slots int) (done func(), err error) {
+	held := 0
+	done = func() {
+		for ; held > 0; held-- {
+			<-s.slots
+		}
+	}
+	for held < slots {
+		select {
+		case s.slots <- struct{}{}:
+			held++
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+
+	for s.MaxLoad > 0 {
+		load, err := loadAverage()
+		if err != nil || load <= s.MaxLoad {
+			break
+		}
+		select {
+		case <-time.After(loadCheckInterval):
+		case <-ctx.Done():
+			done()
+			return nil, ctx.Err()
+		}
+	}
+	return done, nil
+}
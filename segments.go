@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Segment modes: what happens to announcements and other non-music parts of
+// the recording.
+const (
+	// SegmentsExclude ends the track before a segment where the segment
+	// starts, leaving the segment out of every output.
+	SegmentsExclude = "exclude"
+	// SegmentsBonus writes each segment as its own labelled file.
+	SegmentsBonus = "bonus"
+	// SegmentsInclude leaves a segment at the end of the track before it.
+	SegmentsInclude = "include"
+)
+
+// bonusArtist is the artist of segments written as files by --segments bonus.
+const bonusArtist = "Bonus"
+
+var (
+	// segmentMarkerRe matches a marker line such as "MC", "Break" or
+	// "Intro: welcome".
+	segmentMarkerRe = regexp.MustCompile(`(?i)^(MC|Intro|Outro|Break|Interval|Interlude|Speech|Announcement)(?:\s*:\s*.*)?$`)
+	// onStageRe matches "Artist On Stage" announcements.
+	onStageRe = regexp.MustCompile(`^.+\sOn Stage$`)
+
+	silenceStartRe = regexp.MustCompile(`silence_start: (-?[\d.]+)`)
+	silenceEndRe   = regexp.MustCompile(`silence_end: (-?[\d.]+)`)
+)
+
+// silenceThreshold is the level below which --detect-silence counts audio
+// as silent.
+const silenceThreshold = "-50dB"
+
+// silenceSlack is how far from a track boundary a silence may stop short
+// and still be trimmed from it.
+const silenceSlack = 1.0
+
+// parseSegment reports the kind of non-music segment a tracklist line
+// describes, such as "MC" or "On Stage", if it is one.
+func parseSegment(text string) (string, bool) {
+	if m := segmentMarkerRe.FindStringSubmatch(text); m != nil {
+		return m[1], true
+	}
+	if onStageRe.MatchString(text) {
+		return "On Stage", true
+	}
+	return "", false
+}
+
+func validSegmentsMode(mode string) bool {
+	switch mode {
+	case "", SegmentsExclude, SegmentsBonus, SegmentsInclude:
+		return true
+	}
+	return false
+}
+
+// applySegments resolves the segments of tracks with known start times
+// under mode: excluded segments end the track before them and are dropped,
+// included ones are dropped so the track before runs through them, and
+// bonus ones are kept as tracks of their own.
+func applySegments(tracks []Track, mode string) []Track {
+	var out []Track
+	for _, t := range tracks {
+		if t.Segment == "" {
+			out = append(out, t)
+			continue
+		}
+		switch mode {
+		case SegmentsBonus:
+			t.MainArtist = bonusArtist
+			out = append(out, t)
+		case SegmentsInclude:
+		default:
+			if n := len(out); n > 0 && out[n-1].EndTime == 0 {
+				out[n-1].EndTime = t.StartTime
+			}
+		}
+	}
+	return out
+}
+
+// musicTracks leaves out the tracklist's non-music segments.
+func musicTracks(tracks []Track) []Track {
+	var out []Track
+	for _, t := range tracks {
+		if t.Segment == "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// detectSilence lists the stretches of the input's audio stream audioMap
+// quieter than silenceThreshold for at least minLength seconds.
+func detectSilence(input, audioMap string, minLength float64) ([][2]float64, error) {
+	args := append([]string{"-hide_banner", "-nostats"}, inputArgs(input)...)
+	args = append(args, "-map", audioMap,
+		"-af", fmt.Sprintf("silencedetect=n=%s:d=%f", silenceThreshold, minLength), "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	if err := runFFmpeg(context.Background(), &Command{Args: args, Stderr: &stderr}); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\n%s", err, stderr.String())
+	}
+
+	starts := silenceStartRe.FindAllStringSubmatch(stderr.String(), -1)
+	ends := silenceEndRe.FindAllStringSubmatch(stderr.String(), -1)
+	var silences [][2]float64
+	for i, m := range starts {
+		s, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		// Silence running to the end of the input has no silence_end.
+		e := -1.0
+		if i < len(ends) {
+			if e, err = strconv.ParseFloat(ends[i][1], 64); err != nil {
+				return nil, err
+			}
+		}
+		silences = append(silences, [2]float64{max(s, 0), e})
+	}
+	return silences, nil
+}
+
+// trimSilence cuts silences at the start or end of a track out of it.
+// Silence in the middle of a track is left alone, since it is more likely a
+// breakdown than a gap between tracks. An end of -1 means the silence runs
+// to the end of the input.
+func trimSilence(tracks []Track, silences [][2]float64) {
+	for i := range tracks {
+		t := &tracks[i]
+		for _, s := range silences {
+			start, end := s[0], s[1]
+			if end < 0 {
+				end = t.EndTime
+			}
+			if start > t.StartTime && start < t.EndTime && end >= t.EndTime-silenceSlack {
+				t.EndTime = start
+			} else if start <= t.StartTime+silenceSlack && end > t.StartTime && end < t.EndTime {
+				t.StartTime = end
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplySegments(t *testing.T) {
+	tracklist := func() []Track {
+		return []Track{
+			{Line: 1, StartTime: 0, MainArtist: "A", MainTitle: "One"},
+			{Line: 2, StartTime: 100, Segment: "MC", MainTitle: "MC"},
+			{Line: 3, StartTime: 120, MainArtist: "B", MainTitle: "Two"},
+			{Line: 4, StartTime: 200, MainArtist: "C", MainTitle: "Three", EndTime: 210},
+			{Line: 5, StartTime: 220, Segment: "Break", MainTitle: "Break"},
+		}
+	}
+	type kept struct {
+		line   int
+		artist string
+		end    float64
+	}
+	tests := []struct {
+		mode string
+		want []kept
+	}{
+		{mode: SegmentsExclude, want: []kept{{1, "A", 100}, {3, "B", 0}, {4, "C", 210}}},
+		{mode: "", want: []kept{{1, "A", 100}, {3, "B", 0}, {4, "C", 210}}},
+		{mode: SegmentsInclude, want: []kept{{1, "A", 0}, {3, "B", 0}, {4, "C", 210}}},
+		{mode: SegmentsBonus, want: []kept{{1, "A", 0}, {2, bonusArtist, 0}, {3, "B", 0}, {4, "C", 210}, {5, bonusArtist, 0}}},
+	}
+	for _, tt := range tests {
+		var got []kept
+		for _, tr := range applySegments(tracklist(), tt.mode) {
+			got = append(got, kept{tr.Line, tr.MainArtist, tr.EndTime})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("applySegments(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//go:embed web/*.html
+var webFS embed.FS
+
+// mediaExtensions limits which files under --media-dir are offered as inputs.
+var mediaExtensions = map[string]bool{
+	".mp3": true, ".mp4": true, ".m4a": true, ".mkv": true, ".webm": true,
+	".flac": true, ".wav": true, ".aac": true, ".ogg": true, ".opus": true,
+	".mov": true, ".ts": true,
+}
+
+// maxFormField bounds the size of non-file form fields such as the pasted
+// tracklist.
+const maxFormField = 1 << 20
+
+// defaultMaxUpload is --max-upload's default, enough for a few hours of
+// video.
+const defaultMaxUpload byteSize = 10 << 30
+
+type JobPhase string
+
+const (
+	PhaseQueued    JobPhase = "queued"
+	PhaseRunning   JobPhase = "running"
+	PhaseFinished  JobPhase = "finished"
+	PhaseFailed    JobPhase = "failed"
+	PhaseCancelled JobPhase = "cancelled"
+)
+
+// serverJob wraps a Job with its bookkeeping in serve mode.
+type serverJob struct {
+	// Job is the first of formats, whose progress the job reports.
+	*Job
+	ID       string
+	Created  time.Time
+	InputRef string
+
+	// formats are the jobs run one after another, one per output format
+	// (see splitFormats), all under dir.
+	formats []*Job
+	dir     string
+
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	phase JobPhase
+	err   string
+}
+
+func (sj *serverJob) Phase() JobPhase {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	return sj.phase
+}
+
+func (sj *serverJob) Err() string {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	return sj.err
+}
+
+func (sj *serverJob) setPhase(p JobPhase, err error) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	sj.phase = p
+	if err != nil {
+		sj.err = err.Error()
+	}
+}
+
+type server struct {
+	ctx      context.Context
+	dataDir  string
+	mediaDir string
+	apiToken string
+	// maxUpload caps the size of an upload form, in bytes.
+	maxUpload int64
+	logger    *slog.Logger
+
+	// defaults holds the processing flags given to serve; every job starts
+	// from a copy.
+	defaults Options
+	tmpl     *template.Template
+
+	// slots limits how many jobs encode at once; each job already runs
+	// maxWorkers ffmpeg processes.
+	slots chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*serverJob
+}
+
+func serveCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	addr := flags.String("addr", ":8080", "Address to listen on")
+	dataDir := flags.String("data-dir", "splitter-data", "Directory for uploads and job outputs")
+	mediaDir := flags.String("media-dir", "", "Directory of existing media files offered as inputs")
+	apiToken := flags.String("api-token", os.Getenv("SPLITTER_API_TOKEN"), "Token required for every request, as a bearer token or the web UI's password (default $SPLITTER_API_TOKEN)")
+	maxUpload := defaultMaxUpload
+	flags.Var(&maxUpload, "max-upload", "Largest upload accepted, e.g. 4G")
+	var defaults Options
+	bindProcessingFlags(flags, &defaults)
+	bindSchedulerFlags(flags)
+	return func(logger *slog.Logger) error {
+		if err := procs.validate(); err != nil {
+			return err
+		}
+		for _, dir := range []string{"uploads", "jobs"} {
+			if err := os.MkdirAll(filepath.Join(*dataDir, dir), 0755); err != nil {
+				return err
+			}
+		}
+
+		tmpl, err := template.New("").Funcs(template.FuncMap{
+			"timestamp": formatTimestamp,
+			"title":     func(t Track) string { return buildTitle(&t) },
+			"inc":       func(i int) int { return i + 1 },
+		}).ParseFS(webFS, "web/*.html")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		s := &server{
+			ctx:       ctx,
+			dataDir:   *dataDir,
+			mediaDir:  *mediaDir,
+			apiToken:  *apiToken,
+			maxUpload: int64(maxUpload),
+			defaults:  defaults,
+			logger:    logger,
+			tmpl:      tmpl,
+			slots:     make(chan struct{}, 1),
+			jobs:      make(map[string]*serverJob),
+		}
+
+		srv := &http.Server{Addr: *addr, Handler: s.routes()}
+
+		interruptChan := make(chan os.Signal, 1)
+		signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interruptChan
+			logger.Info("Received interrupt signal, shutting down...")
+			cancel()
+			shutdownCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+			defer done()
+			srv.Shutdown(shutdownCtx)
+		}()
+
+		logger.Info("Serving web UI", "addr", *addr, "dataDir", *dataDir, "mediaDir", *mediaDir)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleIndex)
+	mux.HandleFunc("POST /preview", s.handlePreview)
+	mux.HandleFunc("POST /jobs", s.handleStart)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /jobs/{id}/download", s.handleDownload)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancel)
+
+	mux.Handle("/api/", s.apiRoutes())
+	return s.requireToken(mux)
+}
+
+func (s *server) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tmpl.ExecuteTemplate(w, name, data); err != nil {
+		s.logger.Error("Template rendering failed", "template", name, "error", err)
+	}
+}
+
+func (s *server) renderError(w http.ResponseWriter, code int, err error) {
+	w.WriteHeader(code)
+	s.render(w, "error.html", err.Error())
+}
+
+func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	media, err := s.listMedia()
+	if err != nil {
+		s.logger.Warn("Listing media directory failed", "error", err)
+	}
+	s.render(w, "index.html", map[string]any{
+		"Media": media,
+		"Jobs":  s.jobList(),
+	})
+}
+
+// handlePreview accepts the upload form, stores any uploaded file and shows
+// the cut plan without starting anything.
+func (s *server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	fields, err := s.readUploadForm(w, r)
+	if err != nil {
+		code := http.StatusBadRequest
+		if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		s.renderError(w, code, err)
+		return
+	}
+
+	ref := fields["media"]
+	if fields["upload"] != "" {
+		ref = "upload:" + fields["upload"]
+	} else if ref != "" {
+		ref = "media:" + ref
+	}
+
+	job, err := s.planJob(ref, fields["tracklist"], fields["format"], "")
+	if err != nil {
+		s.renderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.render(w, "preview.html", map[string]any{
+		"Job":       job,
+		"InputRef":  ref,
+		"Tracklist": fields["tracklist"],
+		"Format":    fields["format"],
+	})
+}
+
+func (s *server) handleStart(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	sj, err := s.submit(r.PostForm.Get("input"), r.PostForm.Get("tracklist"), r.PostForm.Get("format"))
+	if err != nil {
+		s.renderError(w, statusFor(err), err)
+		return
+	}
+	http.Redirect(w, r, "/jobs/"+sj.ID, http.StatusSeeOther)
+}
+
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		s.renderError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	sj.cancel()
+	http.Redirect(w, r, "/jobs/"+sj.ID, http.StatusSeeOther)
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		s.renderError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+
+	phase := sj.Phase()
+	states := sj.States()
+	done := 0
+	for _, st := range states {
+		if st.Status == StatusDone || st.Status == StatusFailed {
+			done++
+		}
+	}
+
+	s.render(w, "job.html", map[string]any{
+		"Job":    sj,
+		"Phase":  phase,
+		"Error":  sj.Err(),
+		"States": states,
+		"Done":   done,
+		"Active": phase == PhaseQueued || phase == PhaseRunning,
+	})
+}
+
+func (s *server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	sj := s.job(r.PathValue("id"))
+	if sj == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if sj.Phase() != PhaseFinished {
+		http.Error(w, "job has not finished", http.StatusConflict)
+		return
+	}
+
+	name := sanitizeFilename(sj.Album)
+	if name == "" {
+		name = sj.ID
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	if err := writeZip(w, sj.dir, name); err != nil {
+		s.logger.Error("Streaming zip failed", "job", sj.ID, "error", err)
+	}
+}
+
+// submitError marks errors caused by the submitted job rather than the
+// server, so handlers can answer 400 instead of 500.
+type submitError struct{ error }
+
+func statusFor(err error) int {
+	var se submitError
+	if errors.As(err, &se) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// submit plans a job from an input reference and tracklist text, sets up its
+// directory under --data-dir and queues it.
+func (s *server) submit(ref, tracklist, format string) (*serverJob, error) {
+	id := newJobID()
+	jobDir := filepath.Join(s.dataDir, "jobs", id)
+	job, err := s.planJob(ref, tracklist, format, filepath.Join(jobDir, outputDir))
+	if err != nil {
+		return nil, submitError{err}
+	}
+
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return nil, err
+	}
+	// Keep the tracklist next to the outputs so the job can be rerun by hand.
+	tracklistPath := filepath.Join(jobDir, "tracklist.txt")
+	if err := os.WriteFile(tracklistPath, []byte(tracklist), 0644); err != nil {
+		return nil, err
+	}
+	job.Tracklist = tracklistPath
+
+	formats := job.splitFormats()
+	for _, fj := range formats {
+		if err := fj.openCheckpoint(false); err != nil {
+			return nil, err
+		}
+	}
+	if err := mkdirJobs(formats); err != nil {
+		return nil, err
+	}
+
+	sj := &serverJob{
+		Job:      formats[0],
+		ID:       id,
+		Created:  time.Now(),
+		InputRef: ref,
+		formats:  formats,
+		dir:      job.OutputDir,
+		phase:    PhaseQueued,
+	}
+	s.mu.Lock()
+	s.jobs[id] = sj
+	s.mu.Unlock()
+
+	s.start(sj)
+	return sj, nil
+}
+
+// start runs the job in the background once an encode slot frees up.
+func (s *server) start(sj *serverJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	sj.cancel = cancel
+
+	go func() {
+		defer cancel()
+
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-ctx.Done():
+			sj.setPhase(PhaseCancelled, nil)
+			return
+		}
+
+		sj.setPhase(PhaseRunning, nil)
+		s.logger.Info("Starting job", "job", sj.ID, "album", sj.Album, "trackCount", len(sj.Tracks))
+
+		failed, total := 0, 0
+		for _, job := range sj.formats {
+			if ctx.Err() != nil || (job.FailFast && failed > 0) {
+				break
+			}
+			failed += runJob(ctx, job, s.logger)
+			total += len(job.Tracks)
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			sj.setPhase(PhaseCancelled, nil)
+		case failed == total:
+			sj.setPhase(PhaseFailed, errors.New("every track failed"))
+		default:
+			sj.setPhase(PhaseFinished, nil)
+		}
+		s.logger.Info("Job ended", "job", sj.ID, "failed", failed)
+	}()
+}
+
+// planJob resolves an input reference and tracklist text into a planned Job.
+func (s *server) planJob(ref, tracklist, format, outDir string) (*Job, error) {
+	input, err := s.resolveInput(ref)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(tracklist) == "" {
+		return nil, errors.New("a tracklist is required")
+	}
+
+	o := s.defaults
+	o.Input = input
+	o.OutputDir = outDir
+	switch format {
+	case "audio":
+		o.Audio = true
+	case "video":
+		o.Video = true
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+
+	return newJob(o, strings.NewReader(tracklist))
+}
+
+// resolveInput maps "upload:<name>" and "media:<relative path>" references
+// to files on disk, refusing anything outside their base directory. HTTP(S)
+// URLs are passed through for ffmpeg to fetch.
+func (s *server) resolveInput(ref string) (string, error) {
+	if isURL(ref) {
+		return ref, nil
+	}
+
+	kind, name, _ := strings.Cut(ref, ":")
+	var base string
+	switch kind {
+	case "upload":
+		base = filepath.Join(s.dataDir, "uploads")
+	case "media":
+		if s.mediaDir == "" {
+			return "", errors.New("no media directory configured")
+		}
+		base = s.mediaDir
+	case "":
+		return "", errors.New("upload a file or choose one from the media directory")
+	default:
+		return "", fmt.Errorf("unknown input reference %q", ref)
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("invalid input path %q", name)
+	}
+	p := filepath.Join(base, filepath.FromSlash(name))
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", name)
+	}
+	return p, nil
+}
+
+// readUploadForm streams a multipart form of up to --max-upload bytes,
+// saving the "upload" file part into the uploads directory under a unique
+// name. The stored name is returned in place of the file's contents.
+func (s *server) readUploadForm(w http.ResponseWriter, r *http.Request) (map[string]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return fields, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "upload" {
+			if part.FileName() == "" {
+				continue
+			}
+			name, err := s.saveUpload(part)
+			if err != nil {
+				return nil, err
+			}
+			fields["upload"] = name
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormField))
+		if err != nil {
+			return nil, err
+		}
+		fields[part.FormName()] = string(value)
+	}
+}
+
+func (s *server) saveUpload(part *multipart.Part) (string, error) {
+	name := newJobID() + "-" + sanitizeFilename(filepath.Base(part.FileName()))
+	f, err := os.Create(filepath.Join(s.dataDir, "uploads", name))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, part); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		// Returned as is so the handler can answer 413.
+		if tooLarge := new(http.MaxBytesError); errors.As(err, &tooLarge) {
+			return "", err
+		}
+		return "", fmt.Errorf("saving upload: %v", err)
+	}
+	return name, f.Close()
+}
+
+// listMedia returns media files under --media-dir as slash-separated
+// relative paths.
+func (s *server) listMedia() ([]string, error) {
+	if s.mediaDir == "" {
+		return nil, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(s.mediaDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !mediaExtensions[strings.ToLower(filepath.Ext(p))] {
+			return nil
+		}
+		rel, err := filepath.Rel(s.mediaDir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+func (s *server) job(id string) *serverJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// jobList returns all jobs, newest first.
+func (s *server) jobList() []*serverJob {
+	s.mu.Lock()
+	jobs := make([]*serverJob, 0, len(s.jobs))
+	for _, sj := range s.jobs {
+		jobs = append(jobs, sj)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.After(jobs[j].Created) })
+	return jobs
+}
+
+func hasRefPrefix(ref string) bool {
+	return strings.HasPrefix(ref, "upload:") || strings.HasPrefix(ref, "media:")
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
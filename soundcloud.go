@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const soundCloudAPI = "https://api-v2.soundcloud.com"
+
+// commentWindow is how close two comments must be, in seconds, to count as
+// naming the same track, and how close a comment must be to an existing
+// tracklist entry to be treated as a duplicate of it.
+const commentWindow = 60
+
+type scTrack struct {
+	ID       int64  `json:"id"`
+	Kind     string `json:"kind"`
+	Title    string `json:"title"`
+	Duration int64  `json:"duration"` // milliseconds
+	User     struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+type scComment struct {
+	Body      string `json:"body"`
+	Timestamp *int64 `json:"timestamp"` // milliseconds into the track
+	User      struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// commentCandidate is a track suggestion harvested from one or more
+// timestamped comments.
+type commentCandidate struct {
+	Start      float64
+	Artist     string
+	Title      string
+	Confidence float64
+	Comments   []string
+}
+
+var (
+	commentTimeRe  = regexp.MustCompile(`(?:^|[\s\[(])(\d{1,2}:\d{2}(?::\d{2})?)(?:[\s\])\-:]|$)`)
+	commentTrackRe = regexp.MustCompile(`^(.+?)\s+[-–—]\s+(.+)$`)
+	mentionRe      = regexp.MustCompile(`@\S+`)
+	questionRe     = regexp.MustCompile(`(?i)\bid\s*\?|\?|anyone know|what (?:is|'s) (?:this|the)|track ?id\b`)
+	idPrefixRe     = regexp.MustCompile(`(?i)^(?:(?:track\s*id|id|track|tracklist)\b\W*|(?:it'?s|this is)\s+)`)
+)
+
+func soundCloudCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	clientID := flags.String("client-id", os.Getenv("SOUNDCLOUD_CLIENT_ID"), "SoundCloud API client ID (default $SOUNDCLOUD_CLIENT_ID)")
+	tracklistPath := flags.String("tracklist", "", "Existing tracklist to augment with comment-derived tracks")
+	outDir := flags.String("out", ".", "Directory for the downloaded audio, tracklist and review file")
+	threshold := flags.Float64("min-confidence", 0.6, "Minimum confidence (0-1) for a comment-derived track to enter the tracklist")
+	noDownload := flags.Bool("no-download", false, "Only build the tracklist; do not download the audio")
+	ytdlp := flags.String("yt-dlp", "yt-dlp", "yt-dlp executable used to download the audio")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: song-splitter soundcloud [flags] <soundcloud url>")
+		flags.PrintDefaults()
+	}
+	return func(logger *slog.Logger) error {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return errors.New("exactly one SoundCloud URL is required")
+		}
+		if *clientID == "" {
+			return errors.New("--client-id or $SOUNDCLOUD_CLIENT_ID is required")
+		}
+		pageURL := flags.Arg(0)
+		ctx := context.Background()
+
+		track, err := resolveSoundCloud(ctx, pageURL, *clientID)
+		if err != nil {
+			return err
+		}
+		comments, err := fetchSoundCloudComments(ctx, track.ID, *clientID)
+		if err != nil {
+			return err
+		}
+		candidates := harvestComments(comments)
+		logger.Info("Harvested comments", "title", track.Title, "comments", len(comments), "candidates", len(candidates))
+
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+
+		header := track.Title
+		var existing []Track
+		if *tracklistPath != "" {
+			f, err := os.Open(*tracklistPath)
+			if err != nil {
+				return err
+			}
+			existing, header, err = parseTracklist(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("parse tracklist: %v", err)
+			}
+		}
+
+		tracks, accepted := mergeCandidates(existing, candidates, *threshold)
+		tracklistOut := filepath.Join(*outDir, "tracklist.txt")
+		if err := writeFile(tracklistOut, func(f *os.File) error { return writeTracklist(f, header, tracks) }); err != nil {
+			return err
+		}
+		reviewOut := filepath.Join(*outDir, "review.txt")
+		if err := writeFile(reviewOut, func(f *os.File) error { return writeCommentReview(f, candidates, *threshold) }); err != nil {
+			return err
+		}
+		logger.Info("Wrote tracklist", "path", tracklistOut, "added", accepted, "review", reviewOut)
+
+		if *noDownload {
+			return nil
+		}
+		audio, err := downloadWithYtDlp(ctx, *ytdlp, pageURL, *outDir)
+		if err != nil {
+			return err
+		}
+		logger.Info("Downloaded audio", "path", audio)
+		fmt.Printf("Review %s, then run:\n  song-splitter --input %q --tracklist %q --audio\n", reviewOut, audio, tracklistOut)
+		return nil
+	}
+}
+
+func resolveSoundCloud(ctx context.Context, pageURL, clientID string) (*scTrack, error) {
+	q := url.Values{"url": {pageURL}, "client_id": {clientID}}
+	var track scTrack
+	if err := getJSON(ctx, soundCloudAPI+"/resolve?"+q.Encode(), &track); err != nil {
+		return nil, fmt.Errorf("resolve %s: %v", pageURL, err)
+	}
+	if track.Kind != "track" {
+		return nil, fmt.Errorf("%s is a %s, not a track", pageURL, track.Kind)
+	}
+	return &track, nil
+}
+
+func fetchSoundCloudComments(ctx context.Context, trackID int64, clientID string) ([]scComment, error) {
+	q := url.Values{"client_id": {clientID}, "threaded": {"0"}, "limit": {"200"}}
+	next := fmt.Sprintf("%s/tracks/%d/comments?%s", soundCloudAPI, trackID, q.Encode())
+
+	var all []scComment
+	for next != "" {
+		var page struct {
+			Collection []scComment `json:"collection"`
+			NextHref   string      `json:"next_href"`
+		}
+		if err := getJSON(ctx, next, &page); err != nil {
+			return nil, fmt.Errorf("fetch comments: %v", err)
+		}
+		all = append(all, page.Collection...)
+
+		next = ""
+		if page.NextHref != "" {
+			// next_href omits the client ID.
+			u, err := url.Parse(page.NextHref)
+			if err != nil {
+				return nil, err
+			}
+			v := u.Query()
+			v.Set("client_id", clientID)
+			u.RawQuery = v.Encode()
+			next = u.String()
+		}
+	}
+	return all, nil
+}
+
+func getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// harvestComments turns comments of the form "34:20 Artist - Title" (or an
+// "Artist - Title" comment pinned to a position in the waveform) into track
+// candidates. Comments naming the same track close together are merged and
+// raise its confidence; questions lower it.
+func harvestComments(comments []scComment) []*commentCandidate {
+	var candidates []*commentCandidate
+	byKey := make(map[string][]*commentCandidate)
+
+	for _, c := range comments {
+		body := strings.TrimSpace(mentionRe.ReplaceAllString(c.Body, ""))
+
+		start := -1.0
+		if c.Timestamp != nil {
+			start = float64(*c.Timestamp) / 1000
+		}
+		score := 0.4
+		if m := commentTimeRe.FindStringSubmatchIndex(body); m != nil {
+			if ts, err := parseTimestamp(body[m[2]:m[3]]); err == nil {
+				start = ts
+				score += 0.2
+			}
+			body = strings.TrimSpace(body[:m[2]] + " " + body[m[3]:])
+			body = strings.TrimLeft(body, "-–—:] ")
+		}
+		if start < 0 {
+			continue
+		}
+
+		body = idPrefixRe.ReplaceAllString(body, "")
+		m := commentTrackRe.FindStringSubmatch(body)
+		if m == nil {
+			continue
+		}
+		if questionRe.MatchString(body) {
+			score -= 0.3
+		}
+
+		artist := strings.TrimSpace(m[1])
+		title := strings.TrimRight(strings.TrimSpace(m[2]), "?!. ")
+		key := normalizeTrackKey(artist + " " + title)
+		raw := fmt.Sprintf("[%s] %s: %s", formatTimestamp(start), c.User.Username, c.Body)
+
+		var match *commentCandidate
+		for _, cand := range byKey[key] {
+			if math.Abs(cand.Start-start) <= commentWindow {
+				match = cand
+				break
+			}
+		}
+		if match == nil {
+			match = &commentCandidate{Start: start, Artist: artist, Title: title}
+			byKey[key] = append(byKey[key], match)
+			candidates = append(candidates, match)
+			match.Confidence = score
+		} else {
+			// Agreement between commenters is the strongest signal; keep
+			// the earliest position since people comment after a drop.
+			match.Start = math.Min(match.Start, start)
+			match.Confidence += math.Max(score, 0) / 2
+		}
+		match.Comments = append(match.Comments, raw)
+	}
+
+	for _, c := range candidates {
+		c.Confidence = math.Max(0, math.Min(1, c.Confidence))
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Start < candidates[j].Start })
+	return candidates
+}
+
+// mergeCandidates adds candidates at or above threshold to the existing
+// tracks unless one already starts within commentWindow of them. It returns
+// the combined tracks in order and how many were added.
+func mergeCandidates(existing []Track, candidates []*commentCandidate, threshold float64) ([]Track, int) {
+	tracks := append([]Track(nil), existing...)
+	added := 0
+	for _, c := range candidates {
+		if c.Confidence < threshold {
+			continue
+		}
+		dup := false
+		for _, t := range tracks {
+			if math.Abs(t.StartTime-c.Start) <= commentWindow {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		tracks = append(tracks, Track{StartTime: c.Start, MainArtist: c.Artist, MainTitle: c.Title})
+		added++
+	}
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].StartTime < tracks[j].StartTime })
+	return tracks, added
+}
+
+func writeCommentReview(f *os.File, candidates []*commentCandidate, threshold float64) error {
+	for _, c := range candidates {
+		verdict := "accepted"
+		if c.Confidence < threshold {
+			verdict = "below threshold"
+		}
+		fmt.Fprintf(f, "[%s] %s - %s (confidence %.2f, %s)\n",
+			formatTimestamp(c.Start), c.Artist, c.Title, c.Confidence, verdict)
+		for _, raw := range c.Comments {
+			fmt.Fprintf(f, "    %s\n", raw)
+		}
+	}
+	return nil
+}
+
+// normalizeTrackKey reduces an artist/title string to lowercase letters and
+// digits so trivial spelling differences between commenters still match.
+func normalizeTrackKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+func downloadWithYtDlp(ctx context.Context, ytdlp, pageURL, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, ytdlp,
+		"--no-progress", "--print", "after_move:filepath",
+		"-o", filepath.Join(dir, "%(title)s.%(ext)s"), pageURL)
+	setCancel(cmd)
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s error: %v", ytdlp, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return lines[len(lines)-1], nil
+}
+
+// writeFile creates path and hands it to fill, closing it afterwards.
+func writeFile(path string, fill func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := fill(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,1081 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options holds the settings for a single split run. The CLI fills it from
+// flags; serve mode builds one per submitted job.
+type Options struct {
+	Tracklist string
+	Input     string
+	// InputParts are the files Input, their ffconcat playlist, joins when
+	// more than one was given.
+	InputParts []string
+	// AudioStream and VideoStream pick the input's streams, by index among
+	// the streams of their type or by language; empty takes the first.
+	AudioStream string
+	VideoStream string
+	Audio       bool
+	Video       bool
+	OutputDir   string
+	// Overrides is a JSON file of per-track corrections applied after
+	// parsing.
+	Overrides string
+	// Align lists reference recordings of tracks, located in the input to
+	// correct the tracklist's start times. AlignWindow is how far from the
+	// tracklist start each is searched for.
+	Align       alignList
+	AlignWindow time.Duration
+	// DetectBoundaries places tracks without a timestamp at transitions
+	// found in the audio instead of spreading them evenly.
+	DetectBoundaries bool
+	// FailFast stops the run at the first track that fails.
+	FailFast bool
+	// RecordingStart is the time of day the recording began, HH:MM or
+	// HH:MM:SS, when the tracklist gives times of day instead of offsets.
+	RecordingStart string
+	// MeasureDuration reads the input through to measure its length rather
+	// than trusting its container's.
+	MeasureDuration bool
+	// Album overrides the tracklist header as the album name.
+	Album string
+	// Date and Year set the date tag; Date wins when both are given.
+	Date string
+	Year string
+	// StripVersion drops the version suffix from the title tag.
+	StripVersion bool
+	// AlbumArtist, when set, replaces each track's own credit as the album
+	// artist so players keep the set together.
+	AlbumArtist string
+	Compilation bool
+	Genre       string
+	// Tags are extra "key=value" tags applied to every output, after the
+	// generated ones so they can override them.
+	Tags tagList
+	// Disc is written as the disc tag, as "X" or "X/Y", for sets recorded
+	// in several parts.
+	Disc string
+
+	// ID3Version (3 or 4) and ID3Encoding ("utf8" or "utf16") control MP3
+	// tags; ffmpeg ties the encoding to the version. ID3v1 adds a v1 tag.
+	ID3Version  int
+	ID3Encoding string
+	ID3v1       bool
+	// Lyrics is "", "embed", "lrc" or "both".
+	Lyrics      string
+	LyricsCache string
+	// Channels is "", "mono", "stereo" or "keep", and SampleRate "", "keep"
+	// or a rate in Hz. Unset, audio outputs keep the source's and video
+	// outputs are stereo at 48 kHz.
+	Channels   string
+	SampleRate string
+	// KeepChannels keeps surround sources surround, choosing codecs that
+	// can hold it.
+	KeepChannels bool
+	// Format lists the output formats, comma-separated: any of "mp3" (the
+	// default), "flac" and "opus" for audio, and "mp4" (the default) or
+	// "webm" for video. VideoCodec is "vp9" (the default) or "av1" for WebM.
+	Format     string
+	VideoCodec string
+	// VideoCopy copies the video stream instead of re-encoding it, moving
+	// each cut to the nearest keyframe.
+	VideoCopy bool
+	// Transform crops, rotates and scales every video output; overrides
+	// can change it per track.
+	Transform VideoTransform
+	// Ends trims where every track ends; overrides can change it per
+	// track. Duplicates is "merge" (the default) or "error": whether a
+	// track starting when the one before does is merged into it.
+	Ends       EndRules
+	Duplicates string
+	// Segments is "exclude" (the default), "bonus" or "include": whether
+	// announcements and breaks marked in the tracklist are cut out, written
+	// as files of their own or left in the track before. DetectSilence also
+	// trims silences of at least this length from the ends of tracks.
+	Segments      string
+	DetectSilence time.Duration
+	// Preview renders only a clip of this length from the middle of each
+	// track, quickly and at low quality.
+	Preview time.Duration
+	// TitleOverlay burns the artist and title into the first part of each
+	// video output, this long, fading in and out over OverlayFade.
+	// OverlayFont is a font file, and OverlayPosition the corner it sits in.
+	TitleOverlay    time.Duration
+	OverlayFade     time.Duration
+	OverlayFont     string
+	OverlayPosition string
+	// Waveforms is "", "tracks", "set" or "both".
+	Waveforms string
+	// Thumbnails saves a frame of every video track as a JPEG and embeds it
+	// as the cover of tracks without artwork.
+	Thumbnails bool
+	// Spectrograms renders a spectrogram of every output.
+	Spectrograms bool
+	// StemsCommand separates each audio output into stems, such as
+	// vocals and instrumental, which are encoded alongside it.
+	StemsCommand string
+	// Analyze measures each track's loudness into the report; AnalyzeTags
+	// also writes ReplayGain tags. Without Audio or Video nothing is
+	// encoded.
+	Analyze     bool
+	AnalyzeTags bool
+	// NML writes a Traktor collection of the outputs.
+	NML bool
+	// Verify probes and decodes every output after splitting.
+	Verify bool
+	// Checksums is "", "sums" or "files".
+	Checksums string
+	// Archive is "", "zip" or "tar.gz"; ArchiveOnly removes the archived
+	// files afterwards.
+	Archive     string
+	ArchiveOnly bool
+	// Upload is an s3:// or webdav:// destination each output is uploaded
+	// to as soon as it is done.
+	Upload string
+	// Filenames controls how track names become output filenames.
+	Filenames FilenamePolicy
+
+	PreTrackHook  string
+	PostTrackHook string
+	PostRunHook   string
+	// NotifyURL receives the report when the job ends; NotifyDesktop shows
+	// a desktop notification.
+	NotifyURL     string
+	NotifyDesktop bool
+}
+
+const (
+	ChannelsMono   = "mono"
+	ChannelsStereo = "stereo"
+	ChannelsKeep   = "keep"
+	SampleRateKeep = "keep"
+)
+
+type TrackStatus string
+
+const (
+	StatusPending TrackStatus = "pending"
+	StatusRunning TrackStatus = "running"
+	StatusDone    TrackStatus = "done"
+	StatusFailed  TrackStatus = "failed"
+)
+
+type TrackState struct {
+	Status TrackStatus
+	Err    string
+	// Progress is the fraction of the track encoded so far, while it is
+	// running.
+	Progress float64
+}
+
+// Job is a fully planned split: the parsed tracklist with end times and
+// output filenames resolved against the probed input.
+type Job struct {
+	Options
+	Album    string
+	Duration float64
+	Tracks   []Track
+	Ext      string
+	// SourceChannels is the channel count of the input's audio, for
+	// --keep-channels.
+	SourceChannels int
+	// streams are the input's streams, and audioStream and videoStream the
+	// ones taken from it.
+	streams     []mediaStream
+	audioStream mediaStream
+	videoStream mediaStream
+
+	// Warnings are the non-fatal tracklist diagnostics found while planning.
+	Warnings []Diagnostic
+	// Alignment records the references located by --align.
+	Alignment []AlignPoint
+
+	// OnUpdate, if set, is called whenever a track changes state.
+	OnUpdate func(i int, st TrackState)
+
+	// siblings are the jobs for further audio formats, whose outputs this
+	// job's ffmpeg commands also write; encodedBy is set on each of them.
+	siblings  []*Job
+	encodedBy *Job
+
+	storage Storage
+	// uploadPrefix keeps batch sets apart at the upload destination.
+	uploadPrefix string
+	uploads      uploaded
+
+	// plan is the job's planHash once openCheckpoint has run, and resumed
+	// marks the tracks an earlier run of it finished.
+	plan         string
+	resumed      []bool
+	checkpointMu sync.Mutex
+
+	mu       sync.Mutex
+	states   []TrackState
+	problems [][]string
+	loudness []*Loudness
+	// stemFiles are the stems written for each track.
+	stemFiles [][]string
+	// sums are the SHA-256 of each output, and sourceSum of the input.
+	sums      []string
+	sourceSum string
+	started   time.Time
+	finished  time.Time
+}
+
+func newJob(opts Options, tracklist io.Reader) (*Job, error) {
+	tracks, album, err := parseTracklist(tracklist)
+	if err != nil {
+		return nil, fmt.Errorf("parse tracklist: %v", err)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("tracklist contains no tracks")
+	}
+	if opts.RecordingStart != "" {
+		if err := clockStarts(tracks, opts.RecordingStart); err != nil {
+			return nil, err
+		}
+	}
+	duration, reported, err := mediaDuration(opts.Input, opts.MeasureDuration)
+	if err != nil {
+		return nil, err
+	}
+	if err := interpolateStarts(tracks, duration); err != nil {
+		return nil, err
+	}
+	streams, err := probeStreams(opts.Input)
+	if err != nil {
+		return nil, err
+	}
+	audioStream, err := selectStream(streams, "audio", opts.AudioStream)
+	if err != nil {
+		return nil, err
+	}
+	var videoStream mediaStream
+	if opts.Video {
+		if videoStream, err = selectStream(streams, "video", opts.VideoStream); err != nil {
+			return nil, err
+		}
+	} else if opts.VideoStream != "" {
+		return nil, fmt.Errorf("--video-stream requires --video")
+	}
+
+	if !validSegmentsMode(opts.Segments) {
+		return nil, fmt.Errorf("invalid segments mode %q: want exclude, bonus or include", opts.Segments)
+	}
+	var alignment []AlignPoint
+	if len(opts.Align) > 0 {
+		audioMap := fmt.Sprintf("0:a:%d", audioStream.Index)
+		numbered := numberedTracks(tracks, opts.Segments, opts.Duplicates)
+		if alignment, err = alignTracks(tracks, numbered, opts.Input, audioMap, opts.Align, opts.AlignWindow.Seconds()); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DetectBoundaries {
+		if err := detectBoundaries(tracks, opts.Input, fmt.Sprintf("0:a:%d", audioStream.Index), duration); err != nil {
+			return nil, fmt.Errorf("detect boundaries: %v", err)
+		}
+	}
+	tracks = applySegments(tracks, opts.Segments)
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("tracklist contains no tracks")
+	}
+	if opts.VideoCopy {
+		if !opts.Video {
+			return nil, fmt.Errorf("--video-copy requires --video")
+		}
+		if opts.Preview > 0 {
+			return nil, fmt.Errorf("--video-copy cannot be combined with --preview")
+		}
+		if opts.DetectSilence > 0 {
+			return nil, fmt.Errorf("--video-copy cannot be combined with --detect-silence")
+		}
+	}
+	if opts.Thumbnails && !opts.Video {
+		return nil, fmt.Errorf("--thumbnails requires --video")
+	}
+	if err := validateOverlay(opts); err != nil {
+		return nil, err
+	}
+	if opts.StemsCommand != "" && !opts.Audio {
+		return nil, fmt.Errorf("--stems requires --audio")
+	}
+	if err := validateFormats(opts); err != nil {
+		return nil, err
+	}
+	if err := validateWebM(opts); err != nil {
+		return nil, err
+	}
+	if opts.KeepChannels {
+		if err := keepChannels(&opts, audioStream.Channels); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.Transform.isZero() && !opts.Video {
+		return nil, fmt.Errorf("--crop, --rotate and --scale require --video")
+	}
+	if err := opts.Transform.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Ends.validate(); err != nil {
+		return nil, err
+	}
+	for i := range tracks {
+		tracks[i].Transform = opts.Transform
+		tracks[i].Ends = opts.Ends
+	}
+	if opts.Overrides != "" {
+		overrides, err := loadOverrides(opts.Overrides)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyOverrides(tracks, numberedTracks(tracks, opts.Segments, opts.Duplicates), overrides); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.VideoCopy {
+		for _, t := range tracks {
+			if !t.Transform.isZero() {
+				return nil, fmt.Errorf("--video-copy cannot crop, rotate or scale the video of %q", t.MainTitle)
+			}
+		}
+		keyframes, err := probeKeyframes(opts.Input, videoStream.Index)
+		if err != nil {
+			return nil, err
+		}
+		snapToKeyframes(tracks, keyframes)
+	}
+
+	if opts.Album != "" {
+		album = opts.Album
+	}
+	if err := checkTagOptions(&opts); err != nil {
+		return nil, err
+	}
+	if !validWaveformMode(opts.Waveforms) {
+		return nil, fmt.Errorf("invalid waveforms mode %q: want tracks, set or both", opts.Waveforms)
+	}
+	if opts.AnalyzeTags && !opts.Analyze {
+		return nil, fmt.Errorf("--analyze-tags requires --analyze")
+	}
+	switch opts.Channels {
+	case "", ChannelsMono, ChannelsStereo, ChannelsKeep:
+	default:
+		return nil, fmt.Errorf("invalid channels %q: want mono, stereo or keep", opts.Channels)
+	}
+	if opts.SampleRate != "" && opts.SampleRate != SampleRateKeep {
+		if n, err := strconv.Atoi(opts.SampleRate); err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid sample rate %q: want a rate in Hz or keep", opts.SampleRate)
+		}
+	}
+	if opts.Checksums != "" && opts.Checksums != ChecksumsManifest && opts.Checksums != ChecksumsFiles {
+		return nil, fmt.Errorf("invalid checksums mode %q: want sums or files", opts.Checksums)
+	}
+	if opts.Archive != "" && opts.Archive != ArchiveZip && opts.Archive != ArchiveTarGz {
+		return nil, fmt.Errorf("invalid archive format %q: want zip or tar.gz", opts.Archive)
+	}
+	if err := opts.Filenames.validate(); err != nil {
+		return nil, err
+	}
+	var storage Storage
+	if opts.Upload != "" {
+		if storage, err = openStorage(opts.Upload); err != nil {
+			return nil, err
+		}
+	}
+	if opts.OutputDir == "" {
+		opts.OutputDir = outputDir
+	}
+
+	tracks = calculateEndTimes(tracks, duration, opts.Duplicates)
+	job := &Job{
+		Options:        opts,
+		Album:          album,
+		Duration:       duration,
+		Tracks:         tracks,
+		Ext:            getOutputExtension(opts),
+		SourceChannels: audioStream.Channels,
+		streams:        streams,
+		audioStream:    audioStream,
+		videoStream:    videoStream,
+		Alignment:      alignment,
+		states:         make([]TrackState, len(tracks)),
+		storage:        storage,
+		uploads:        uploaded{files: make(map[string]UploadEntry)},
+	}
+	for i := range job.states {
+		job.states[i].Status = StatusPending
+	}
+
+	for i := range job.Tracks {
+		job.Tracks[i].Number = i + 1
+	}
+	if opts.DetectSilence > 0 && opts.Segments != SegmentsInclude {
+		silences, err := detectSilence(opts.Input, job.audioMap(), opts.DetectSilence.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		trimSilence(job.Tracks, silences)
+	}
+	if job.Warnings, err = checkTracks(job.Tracks, duration); err != nil {
+		return nil, err
+	}
+	if reported != 0 {
+		job.Warnings = append(job.Warnings, durationWarning(job.Tracks, reported, duration))
+	}
+	createFilenames(job.Tracks, job.OutputDir, job.Ext, job.Filenames)
+	return job, nil
+}
+
+// splitFormats turns a job asking for several output formats into one job
+// per format, each writing to its own folder under the job's output
+// directory: audio/ and video/, or a folder per format name with several
+// audio formats. All share the plan, and the first audio job encodes the
+// other audio formats alongside its own. A single-format job is returned as
+// is.
+func (j *Job) splitFormats() []*Job {
+	audio, video := j.formats()
+	if !j.Audio {
+		audio = nil
+	}
+	if !j.encodes() || (len(audio) == 1 && !j.Video) || (audio == nil && j.Video) {
+		return []*Job{j}
+	}
+
+	var jobs []*Job
+	derive := func(opts Options, name string) *Job {
+		opts.OutputDir = filepath.Join(j.OutputDir, name)
+		job := &Job{
+			Options:        opts,
+			Album:          j.Album,
+			Duration:       j.Duration,
+			Tracks:         append([]Track(nil), j.Tracks...),
+			Ext:            getOutputExtension(opts),
+			SourceChannels: j.SourceChannels,
+			streams:        j.streams,
+			audioStream:    j.audioStream,
+			videoStream:    j.videoStream,
+			Warnings:       j.Warnings,
+			Alignment:      j.Alignment,
+			states:         append([]TrackState(nil), j.states...),
+			storage:        j.storage,
+			uploadPrefix:   path.Join(j.uploadPrefix, name),
+			uploads:        uploaded{files: make(map[string]UploadEntry)},
+		}
+		createFilenames(job.Tracks, job.OutputDir, job.Ext, job.Filenames)
+		jobs = append(jobs, job)
+		return job
+	}
+
+	var leader *Job
+	for _, format := range audio {
+		opts := j.Options
+		opts.Audio, opts.Video, opts.Format = true, false, format
+		opts.VideoCopy, opts.Thumbnails, opts.VideoCodec = false, false, ""
+		name := format
+		if len(audio) == 1 {
+			name = "audio"
+		}
+		if leader != nil {
+			// The leader measures loudness and fills in these outputs' tags.
+			opts.Analyze = false
+		}
+		job := derive(opts, name)
+		if leader == nil {
+			leader = job
+		} else {
+			job.encodedBy = leader
+			leader.siblings = append(leader.siblings, job)
+		}
+	}
+	if j.Video {
+		opts := j.Options
+		opts.Audio, opts.Video, opts.Format = false, true, video
+		if leader != nil {
+			// The audio job already reports the loudness; measure again only
+			// to tag these outputs.
+			opts.Analyze = opts.AnalyzeTags
+		}
+		derive(opts, "video")
+	}
+	return jobs
+}
+
+var (
+	discRe = regexp.MustCompile(`^\d+(/\d+)?$`)
+	dateRe = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+	yearRe = regexp.MustCompile(`^\d{4}$`)
+	// albumYearRe finds a year in an album name such as "Ultra Europe 2025".
+	albumYearRe = regexp.MustCompile(`\b(19|20)\d{2}\b`)
+)
+
+func (j *Job) setState(i int, status TrackStatus, err error) {
+	st := TrackState{Status: status}
+	if err != nil {
+		st.Err = err.Error()
+	}
+
+	j.mu.Lock()
+	j.states[i] = st
+	j.mu.Unlock()
+
+	if j.OnUpdate != nil {
+		j.OnUpdate(i, st)
+	}
+}
+
+// setProgress records how much of a running track has been encoded.
+func (j *Job) setProgress(i int, progress float64) {
+	j.mu.Lock()
+	if j.states[i].Status != StatusRunning {
+		j.mu.Unlock()
+		return
+	}
+	j.states[i].Progress = min(progress, 1)
+	st := j.states[i]
+	j.mu.Unlock()
+
+	if j.OnUpdate != nil {
+		j.OnUpdate(i, st)
+	}
+}
+
+// States returns a snapshot of every track's state.
+func (j *Job) States() []TrackState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]TrackState(nil), j.states...)
+}
+
+// artworkArgs maps the artwork input (input 1) in as an attached picture next
+// to the streams normally taken from the source.
+func artworkArgs(job *Job) []string {
+	if job.Video {
+		return []string{"-map", job.videoMap(), "-map", job.audioMap(), "-map", "1:v:0",
+			"-c:v:1", "copy", "-disposition:v:1", "attached_pic"}
+	}
+	return []string{"-map", job.audioMap(), "-map", "1:v:0",
+		"-c:v", "copy", "-disposition:v", "attached_pic"}
+}
+
+// getMediaDuration is the input's length, measured where its container's
+// cannot be trusted (see mediaDuration).
+func getMediaDuration(path string) (float64, error) {
+	duration, _, err := mediaDuration(path, false)
+	return duration, err
+}
+
+// interpolateStarts spreads tracks without a start time evenly between the
+// nearest known starts, treating the beginning and end of the media as known.
+// A duration of 0 means the end is unknown, so trailing untimed tracks are an
+// error.
+func interpolateStarts(tracks []Track, duration float64) error {
+	prev, prevStart := -1, 0.0
+	for i := 0; i <= len(tracks); i++ {
+		if i < len(tracks) && tracks[i].Estimated {
+			continue
+		}
+		next := duration
+		if i < len(tracks) {
+			next = tracks[i].StartTime
+		} else if duration == 0 && i-prev > 1 {
+			return fmt.Errorf("line %d: cannot estimate a start time after the last timestamp without the media duration", tracks[prev+1].Line)
+		}
+
+		// Tracks prev+1..i-1 are untimed. With no known start before them
+		// the first one starts with the media.
+		gaps := float64(i - prev)
+		if prev < 0 {
+			gaps = float64(i)
+		}
+		for k := prev + 1; k < i; k++ {
+			step := float64(k - prev)
+			if prev < 0 {
+				step = float64(k)
+			}
+			tracks[k].StartTime = prevStart + (next-prevStart)*step/gaps
+		}
+
+		if i < len(tracks) {
+			prev, prevStart = i, next
+		}
+	}
+	return nil
+}
+
+// encodes reports whether the job writes outputs, as opposed to only
+// analyzing the input.
+func (j *Job) encodes() bool {
+	return j.Audio || j.Video
+}
+
+func getOutputExtension(opts Options) string {
+	audio, video := opts.formats()
+	if opts.Audio {
+		return "." + audio[0]
+	}
+	if video == FormatWebM {
+		return ".webm"
+	}
+	return ".mp4"
+}
+
+// createFilenames names each track "NN - Artist - Title" under policy,
+// numbering any names that collide.
+func createFilenames(tracks []Track, dir, ext string, policy FilenamePolicy) {
+	paths := make([]string, len(tracks))
+	for i, t := range tracks {
+		paths[i] = filepath.Join(dir, policy.Filename(ext, fmt.Sprintf("%02d", i+1), t.MainArtist, t.MainTitle))
+	}
+	uniquePaths(paths)
+	for i := range tracks {
+		tracks[i].OutputFilename = paths[i]
+	}
+}
+
+// runJob splits every track and then runs the post-run stages, returning
+// the number of tracks that failed.
+func runJob(ctx context.Context, job *Job, logger *slog.Logger) int {
+	if job.Lyrics != "" {
+		fetchLyrics(ctx, job, logger)
+	}
+
+	// With --fail-fast the first failure stops the other tracks, and the
+	// job is left unfinished as if interrupted.
+	run, abort := context.WithCancel(ctx)
+	defer abort()
+	failed := processTracksConcurrently(run, abort, job, logger)
+	if job.setWaveform() && run.Err() == nil {
+		if path, err := renderSetWaveform(ctx, job); err != nil {
+			logger.Warn("Set waveform failed", "error", err)
+		} else {
+			logger.Info("Wrote set waveform", "path", path)
+		}
+	}
+	if job.Verify && job.encodes() && run.Err() == nil {
+		if flagged := verifyOutputs(ctx, job, logger); flagged > 0 {
+			logger.Warn("Verification flagged outputs", "album", job.Album, "flagged", flagged)
+		}
+	}
+	if job.Analyze && run.Err() == nil {
+		if path, err := writeReport(job); err != nil {
+			logger.Error("Failed to write report", "error", err)
+		} else {
+			logger.Info("Wrote loudness report", "path", path)
+		}
+	}
+	if job.NML && job.encodes() && run.Err() == nil {
+		if path, err := writeJobNML(job); err != nil {
+			logger.Error("Failed to write Traktor collection", "error", err)
+		} else {
+			logger.Info("Wrote Traktor collection", "path", path)
+		}
+	}
+	if job.Checksums != "" && job.encodes() && run.Err() == nil {
+		if err := writeChecksums(job, logger); err != nil {
+			logger.Error("Failed to write checksums", "error", err)
+		}
+	}
+	if job.Archive != "" && run.Err() == nil {
+		if path, err := archiveJob(job, job.ArchiveOnly); err != nil {
+			logger.Error("Failed to write archive", "error", err)
+		} else {
+			logger.Info("Wrote archive", "path", path)
+		}
+	}
+	if job.storage != nil && run.Err() == nil {
+		if err := finishUploads(ctx, job, logger); err != nil {
+			logger.Error("Failed to finish uploads", "error", err)
+		}
+	}
+
+	if job.PostRunHook != "" {
+		if err := runHook(ctx, job.PostRunHook, job.runEnv(failed)); err != nil {
+			logger.Warn("Post-run hook failed", "error", err)
+		}
+	}
+
+	if job.NotifyURL != "" || job.NotifyDesktop {
+		// Notify even when interrupted, so use a context of our own.
+		report := job.Report()
+		summary := notifySummary(report, ctx.Err() != nil)
+		if job.NotifyURL != "" {
+			if err := notifyWebhook(context.Background(), job.NotifyURL, report, summary); err != nil {
+				logger.Warn("Webhook notification failed", "error", err)
+			}
+		}
+		if job.NotifyDesktop {
+			if err := notifyDesktop(context.Background(), summary); err != nil {
+				logger.Warn("Desktop notification failed", "error", err)
+			}
+		}
+	}
+	return failed
+}
+
+// processTracksConcurrently splits every track of the job and returns the
+// number of tracks that failed. With FailFast the first failure calls abort,
+// which is to cancel ctx.
+func processTracksConcurrently(ctx context.Context, abort context.CancelFunc, job *Job, logger *slog.Logger) int {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxWorkers)
+	var errCount atomic.Int32
+
+	job.mu.Lock()
+	job.started = time.Now()
+	job.mu.Unlock()
+	defer func() {
+		job.mu.Lock()
+		job.finished = time.Now()
+		job.mu.Unlock()
+	}()
+
+	for i := range job.Tracks {
+		wg.Add(1)
+		go func(i int, t *Track) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				if job.wasResumed(i) {
+					// The report still needs the measurement.
+					if job.Analyze {
+						l, aerr := analyzeTrack(ctx, t, job)
+						if aerr != nil {
+							logger.Warn("Loudness analysis failed", "track", t.MainTitle, "error", aerr)
+						}
+						job.setLoudness(i, l)
+					}
+					logger.Info("Skipping track finished by an earlier run", "track", t.MainTitle)
+					job.setState(i, StatusDone, nil)
+					return
+				}
+				job.setState(i, StatusRunning, nil)
+				err := job.runTrackHook(ctx, job.PreTrackHook, i, StatusRunning, nil)
+				if err != nil {
+					err = fmt.Errorf("pre-track hook: %v", err)
+				} else if job.Analyze {
+					l, aerr := analyzeTrack(ctx, t, job)
+					if aerr != nil && !job.encodes() {
+						err = fmt.Errorf("analyze: %v", aerr)
+					} else if aerr != nil {
+						logger.Warn("Loudness analysis failed", "track", t.MainTitle, "error", aerr)
+					}
+					job.setLoudness(i, l)
+				}
+				// With analysis only there is no output to post-process.
+				wrote := err == nil && job.encodes()
+				if wrote && job.Thumbnails {
+					if imgErr := renderThumbnail(ctx, job, t); imgErr != nil {
+						logger.Warn("Thumbnail failed", "track", t.MainTitle, "error", imgErr)
+					}
+				}
+				if wrote {
+					err = processTrack(ctx, t, job, false)
+					if errors.Is(err, errOutOfMemory) {
+						logger.Warn("Track ran out of memory, retrying alone with fewer threads", "track", t.MainTitle)
+						err = processTrack(ctx, t, job, true)
+					}
+					wrote = err == nil
+				}
+				if wrote && job.StemsCommand != "" && job.Audio && job.encodedBy == nil {
+					if err = separateStems(ctx, t, job); err != nil {
+						err = fmt.Errorf("stems: %v", err)
+					}
+					wrote = err == nil
+				}
+				if wrote && job.trackWaveforms() {
+					if imgErr := renderTrackWaveform(ctx, t); imgErr != nil {
+						logger.Warn("Waveform failed", "track", t.MainTitle, "error", imgErr)
+					}
+				}
+				if wrote && job.Spectrograms {
+					if imgErr := renderSpectrogram(ctx, t); imgErr != nil {
+						logger.Warn("Spectrogram failed", "track", t.MainTitle, "error", imgErr)
+					}
+				}
+				if wrote && job.storage != nil {
+					err = job.uploadTrack(ctx, t)
+				}
+
+				status := StatusDone
+				if err != nil && ctx.Err() != nil {
+					// Stopped by an interrupt or --fail-fast rather than
+					// failing of its own accord.
+					logger.Warn("Track stopped", "track", t.MainTitle, "error", err)
+					status = StatusFailed
+				} else if err != nil {
+					logger.Error("Track processing failed",
+						"track", t.MainTitle, "error", err)
+					errCount.Add(1)
+					status = StatusFailed
+					if job.FailFast {
+						logger.Error("Stopping the run at the first failed track", "track", t.MainTitle)
+						abort()
+					}
+				}
+				job.setState(i, status, err)
+				if cpErr := job.saveCheckpoint(); cpErr != nil {
+					logger.Warn("Saving progress failed", "error", cpErr)
+				}
+
+				if hookErr := job.runTrackHook(ctx, job.PostTrackHook, i, status, err); hookErr != nil {
+					logger.Warn("Post-track hook failed", "track", t.MainTitle, "error", hookErr)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}(i, &job.Tracks[i])
+	}
+
+	wg.Wait()
+
+	return int(errCount.Load())
+}
+
+// seekPreroll is how far before a cut the fast input seek lands, leaving the
+// rest to be decoded precisely.
+const seekPreroll = 10.0
+
+// seekArgs split a seek to start into a fast, keyframe-based input seek
+// (placed before -i) and an exact output seek over the last few seconds
+// (placed after every input), so a cut deep into a long file only decodes
+// the preroll rather than everything before it.
+func seekArgs(start float64) (coarse, fine []string) {
+	c := max(start-seekPreroll, 0)
+	return []string{"-ss", fmt.Sprintf("%f", c)}, []string{"-ss", fmt.Sprintf("%f", start-c)}
+}
+
+// clipRange is the part of the input rendered for t: the whole track, or with
+// --preview a clip of that length from its middle.
+func (j *Job) clipRange(t *Track) (start, length float64) {
+	start, length = t.StartTime, t.EndTime-t.StartTime
+	if clip := j.Preview.Seconds(); clip > 0 && clip < length {
+		start += (length - clip) / 2
+		length = clip
+	}
+	return start, length
+}
+
+// outputArgs are the options and path of one output of a track's ffmpeg
+// command. Each output seeks and is mapped separately.
+func outputArgs(t *Track, job *Job, artwork string, fine []string, length float64, out string) []string {
+	if !job.canEmbedArtwork() {
+		artwork = ""
+	}
+
+	args := append([]string(nil), fine...)
+	args = append(args,
+		"-t", fmt.Sprintf("%f", length),
+
+		// Memory management and optimization
+		"-max_muxing_queue_size", "1024",
+		"-threads", "2", // Limit threads per process
+	)
+
+	args = append(args, audioFormatArgs(job)...)
+	var filters []string
+	if t.Gain != 0 {
+		filters = append(filters, fmt.Sprintf("volume=%.2fdB", t.Gain))
+	}
+	if job.surround() && (job.webm() || (job.Audio && job.audioFormat() == FormatOpus)) {
+		filters = append(filters, "aformat=channel_layouts="+opusLayouts)
+	}
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	if job.Video && !job.Audio {
+		// Only the first video stream: a cover alongside it is copied.
+		var scale string
+		if job.Preview > 0 {
+			scale = "scale=-2:360"
+		}
+		vfilters := t.Transform.filters(scale)
+		if job.TitleOverlay > 0 {
+			var offset float64
+			if fine != nil {
+				offset, _ = strconv.ParseFloat(fine[1], 64)
+			}
+			vfilters = append(vfilters, titleOverlayFilter(t, job, offset))
+		}
+		if len(vfilters) > 0 {
+			args = append(args, "-filter:v:0", strings.Join(vfilters, ","))
+		}
+	}
+	if job.webm() {
+		args = append(args, webmArgs(job, job.Preview > 0)...)
+	} else if job.Audio && job.audioFormat() != FormatMP3 {
+		args = append(args, audioCodecArgs(job, job.Preview > 0)...)
+	} else if job.Preview > 0 {
+		args = append(args, previewArgs(job)...)
+	} else if job.VideoCopy {
+		args = append(args, "-c:v", "copy")
+		args = append(args, mp4AudioArgs(job, "192k")...)
+		args = append(args,
+			"-avoid_negative_ts", "make_zero",
+			"-movflags", "+faststart+use_metadata_tags",
+			"-y",
+		)
+	} else if job.Video {
+		args = append(args,
+			"-c:v", "libx264", // Use H.264 codec
+			"-preset", "veryfast", // Use faster preset to reduce memory usage
+			"-crf", "23", // Reasonable quality
+			"-vsync", "cfr", // Force constant frame rate
+			"-profile:v", "baseline", // Use baseline profile for better compatibility and less memory
+			"-level", "3.0", // Lower level for less memory usage
+			"-tune", "fastdecode", // Optimize for decoding speed
+		)
+		args = append(args, mp4AudioArgs(job, "192k")...)
+		args = append(args,
+			"-movflags", "+faststart+use_metadata_tags", // Enable fast start and keep custom tags
+			"-y", // Overwrite output
+		)
+	} else {
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+		args = append(args, id3Args(job)...)
+	}
+
+	switch {
+	case artwork != "":
+		args = append(args, artworkArgs(job)...)
+	case job.Audio && (job.audioFormat() != FormatMP3 || job.siblings != nil || job.encodedBy != nil || job.selectsStreams()):
+		// Keep other inputs and any video out of the audio-only output.
+		args = append(args, "-map", job.audioMap())
+	case job.Video && job.selectsStreams():
+		args = append(args, "-map", job.videoMap(), "-map", job.audioMap())
+	}
+
+	args = append(args, buildMetadata(t, job)...)
+	l := job.Loudness(t.Number - 1)
+	if job.encodedBy != nil {
+		l = job.encodedBy.Loudness(t.Number - 1)
+	}
+	if job.AnalyzeTags && l != nil {
+		args = append(args, replayGainTags(l, t.Gain)...)
+	}
+	return append(args, out)
+}
+
+// audioFormatArgs set the channel layout and sample rate of the output.
+func audioFormatArgs(job *Job) []string {
+	channels, rate := job.Channels, job.SampleRate
+	if job.Video {
+		// Stereo at 48 kHz is what players expect from video, and the
+		// rate Opus works at.
+		if channels == "" {
+			channels = ChannelsStereo
+		}
+		if rate == "" {
+			rate = "48000"
+		}
+	}
+
+	var args []string
+	switch channels {
+	case ChannelsMono:
+		args = append(args, "-ac", "1")
+	case ChannelsStereo:
+		args = append(args, "-ac", "2")
+	}
+	if rate != "" && rate != SampleRateKeep {
+		args = append(args, "-ar", rate)
+	}
+	return args
+}
+
+// previewArgs encode quickly at low quality, since previews are only for
+// checking cut points and tags.
+func previewArgs(job *Job) []string {
+	if job.Video {
+		args := []string{"-c:v", "libx264", "-preset", "ultrafast", "-crf", "35"}
+		args = append(args, mp4AudioArgs(job, "96k")...)
+		return append(args, "-movflags", "+faststart+use_metadata_tags", "-y")
+	}
+	return append([]string{"-c:a", "libmp3lame", "-q:a", "7"}, id3Args(job)...)
+}
+
+// encodeArgs is the ffmpeg command rendering length seconds of t from start
+// into the job's output and those of its siblings, each written to out(job).
+func encodeArgs(t *Track, job *Job, start, length float64, out func(j *Job) string) []string {
+	coarse, fine := seekArgs(start)
+	if job.VideoCopy {
+		// The start is a keyframe, which the input seek lands on exactly.
+		coarse, fine = []string{"-ss", fmt.Sprintf("%f", start)}, nil
+	}
+	artwork := t.Artwork
+	if thumb := sidecarPath(t, ".jpg"); artwork == "" && job.Thumbnails && fileExists(thumb) {
+		artwork = thumb
+	}
+
+	// Show warnings for debugging
+	args := append([]string{"-v", "warning"}, coarse...)
+	args = append(args, inputArgs(job.Input)...)
+	if artwork != "" && fine != nil {
+		// The fine seek applies to every input, so shift the cover's one
+		// frame forward by the same amount or it would be discarded.
+		args = append(args, "-itsoffset", fine[1], "-i", artwork)
+	} else if artwork != "" {
+		args = append(args, "-i", artwork)
+	}
+
+	// The other audio formats are written by the same command, so the
+	// source is only decoded once.
+	args = append(args, outputArgs(t, job, artwork, fine, length, out(job))...)
+	for _, sib := range job.siblings {
+		args = append(args, outputArgs(t, sib, artwork, fine, length, out(sib))...)
+	}
+	return args
+}
+
+// processTrack encodes t. lowMemory retries an encode that ran out of memory
+// with every other process held back and fewer threads.
+func processTrack(ctx context.Context, t *Track, job *Job, lowMemory bool) error {
+	// Validate time values
+	if t.StartTime >= t.EndTime {
+		return fmt.Errorf("invalid time range: start(%f) >= end(%f)", t.StartTime, t.EndTime)
+	}
+	if job.encodedBy != nil {
+		return finishSiblingTrack(t, job)
+	}
+
+	start, length := job.clipRange(t)
+	args := encodeArgs(t, job, start, length, func(j *Job) string { return j.Tracks[t.Number-1].OutputFilename })
+	run := runFFmpeg
+	if lowMemory {
+		args, run = lowMemoryArgs(args), runFFmpegAlone
+	}
+	var output bytes.Buffer
+	err := job.runTrackFFmpeg(ctx, t, run, &Command{Args: args, Stderr: &output, Progress: func(done time.Duration) {
+		job.setProgress(t.Number-1, done.Seconds()/length)
+	}})
+	if err != nil {
+		if outOfMemory(ctx, err, output.Bytes()) {
+			return fmt.Errorf("%w: %v", errOutOfMemory, job.trackFFmpegError(ctx, t, err, output.Bytes()))
+		}
+		return job.trackFFmpegError(ctx, t, err, output.Bytes())
+	}
+
+	// A preview clip does not start with the track, so synced lyrics would
+	// be out of step.
+	if job.lrcLyrics() && job.Preview == 0 {
+		if err := writeLRC(t); err != nil {
+			return fmt.Errorf("write lyrics: %v", err)
+		}
+	}
+	return nil
+}
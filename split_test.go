@@ -0,0 +1,48 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterpolateStarts(t *testing.T) {
+	// A negative start marks an untimed track.
+	tests := []struct {
+		name     string
+		starts   []float64
+		duration float64
+		want     []float64
+		wantErr  bool
+	}{
+		{name: "all timed", starts: []float64{0, 100, 200}, duration: 300, want: []float64{0, 100, 200}},
+		{name: "between timestamps", starts: []float64{0, -1, -1, 300}, duration: 600, want: []float64{0, 100, 200, 300}},
+		{name: "before the first", starts: []float64{-1, 200}, duration: 600, want: []float64{0, 200}},
+		{name: "several before the first", starts: []float64{-1, -1, 300}, duration: 600, want: []float64{0, 150, 300}},
+		{name: "after the last", starts: []float64{0, 300, -1}, duration: 600, want: []float64{0, 300, 450}},
+		{name: "none timed", starts: []float64{-1, -1}, duration: 600, want: []float64{0, 300}},
+		{name: "after the last without a duration", starts: []float64{0, -1}, wantErr: true},
+		{name: "before the first without a duration", starts: []float64{-1, 100}, want: []float64{0, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := make([]Track, len(tt.starts))
+			for i, s := range tt.starts {
+				tracks[i] = Track{Line: i + 1, StartTime: max(s, 0), Estimated: s < 0}
+			}
+			err := interpolateStarts(tracks, tt.duration)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("interpolateStarts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := make([]float64, len(tracks))
+			for i, tr := range tracks {
+				got[i] = tr.StartTime
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("starts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
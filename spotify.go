@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	spotifyAPI       = "https://api.spotify.com/v1"
+	spotifyAuthorize = "https://accounts.spotify.com/authorize"
+	spotifyToken     = "https://accounts.spotify.com/api/token"
+	// spotifyBatch is the most tracks Spotify accepts per add request.
+	spotifyBatch = 100
+)
+
+// versionSuffixRe matches a trailing "(Extended Mix)"-style suffix, dropped
+// when an exact search finds nothing.
+var versionSuffixRe = regexp.MustCompile(`\s*[(\[][^)\]]*[)\]]\s*$`)
+
+type spotifyClient struct {
+	token string
+	http  *http.Client
+}
+
+func spotifyCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	tracklistPath := flags.String("tracklist", "", "Tracklist whose tracks are added to the playlist")
+	name := flags.String("name", "", "Playlist name (default: the tracklist header)")
+	clientID := flags.String("client-id", os.Getenv("SPOTIFY_CLIENT_ID"), "Spotify app client ID (default $SPOTIFY_CLIENT_ID)")
+	redirect := flags.String("redirect-uri", "http://127.0.0.1:8888/callback", "OAuth redirect URI registered for the Spotify app")
+	token := flags.String("token", os.Getenv("SPOTIFY_TOKEN"), "Existing access token; skips the OAuth login (default $SPOTIFY_TOKEN)")
+	public := flags.Bool("public", false, "Make the playlist public")
+	dryRun := flags.Bool("dry-run", false, "Search only; do not create a playlist")
+	reportPath := flags.String("report", "", "Write the found/missing report here instead of stdout")
+	return func(logger *slog.Logger) error {
+		if *tracklistPath == "" {
+			return errors.New("--tracklist is required")
+		}
+		f, err := os.Open(*tracklistPath)
+		if err != nil {
+			return err
+		}
+		tracks, header, err := parseTracklist(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parse tracklist: %v", err)
+		}
+		if *name == "" {
+			*name = header
+		}
+
+		ctx := context.Background()
+		if *token == "" {
+			if *clientID == "" {
+				return errors.New("--client-id or $SPOTIFY_CLIENT_ID is required to log in")
+			}
+			if *token, err = spotifyLogin(ctx, *clientID, *redirect); err != nil {
+				return err
+			}
+		}
+		client := &spotifyClient{token: *token, http: &http.Client{Timeout: 30 * time.Second}}
+
+		var found []string
+		var report strings.Builder
+		misses := 0
+		for _, q := range spotifyQueries(tracks) {
+			uri, err := client.search(ctx, q.artist, q.title)
+			if err != nil {
+				return err
+			}
+			if uri == "" {
+				misses++
+				fmt.Fprintf(&report, "MISS  %s - %s\n", q.artist, q.title)
+				continue
+			}
+			found = append(found, uri)
+			fmt.Fprintf(&report, "FOUND %s - %s (%s)\n", q.artist, q.title, uri)
+		}
+		fmt.Fprintf(&report, "%d found, %d missing\n", len(found), misses)
+
+		if !*dryRun && len(found) > 0 {
+			playlist, err := client.createPlaylist(ctx, *name, *public, found)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&report, "Playlist: %s\n", playlist)
+			logger.Info("Created playlist", "name", *name, "url", playlist, "tracks", len(found))
+		}
+
+		if *reportPath != "" {
+			return os.WriteFile(*reportPath, []byte(report.String()), 0644)
+		}
+		fmt.Print(report.String())
+		return nil
+	}
+}
+
+type spotifyQuery struct{ artist, title string }
+
+// spotifyQueries lists every main and "w/" track in order, skipping
+// unidentified "ID - ID" entries, repeats and non-music segments.
+func spotifyQueries(tracks []Track) []spotifyQuery {
+	var queries []spotifyQuery
+	seen := make(map[string]bool)
+	add := func(artist, title string) {
+		key := normalizeTrackKey(artist + " " + title)
+		if strings.EqualFold(artist, "ID") || strings.EqualFold(title, "ID") || seen[key] {
+			return
+		}
+		seen[key] = true
+		queries = append(queries, spotifyQuery{artist, title})
+	}
+	for _, t := range musicTracks(tracks) {
+		add(t.MainArtist, t.MainTitle)
+		for _, a := range t.Additional {
+			add(a.Artist, a.Title)
+		}
+	}
+	return queries
+}
+
+// search returns the URI of the best match for artist/title, or "" when
+// nothing is found even without the title's version suffix.
+func (c *spotifyClient) search(ctx context.Context, artist, title string) (string, error) {
+	for _, t := range []string{title, versionSuffixRe.ReplaceAllString(title, "")} {
+		q := url.Values{
+			"q":     {fmt.Sprintf("track:%s artist:%s", t, artist)},
+			"type":  {"track"},
+			"limit": {"1"},
+		}
+		var res struct {
+			Tracks struct {
+				Items []struct {
+					URI string `json:"uri"`
+				} `json:"items"`
+			} `json:"tracks"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/search?"+q.Encode(), nil, &res); err != nil {
+			return "", err
+		}
+		if len(res.Tracks.Items) > 0 {
+			return res.Tracks.Items[0].URI, nil
+		}
+	}
+	return "", nil
+}
+
+// createPlaylist creates the playlist on the logged-in account, fills it and
+// returns its web URL.
+func (c *spotifyClient) createPlaylist(ctx context.Context, name string, public bool, uris []string) (string, error) {
+	var me struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/me", nil, &me); err != nil {
+		return "", err
+	}
+
+	var playlist struct {
+		ID           string `json:"id"`
+		ExternalURLs struct {
+			Spotify string `json:"spotify"`
+		} `json:"external_urls"`
+	}
+	body := map[string]any{
+		"name":        name,
+		"public":      public,
+		"description": "Created by song-splitter",
+	}
+	if err := c.do(ctx, http.MethodPost, "/users/"+url.PathEscape(me.ID)+"/playlists", body, &playlist); err != nil {
+		return "", err
+	}
+
+	for start := 0; start < len(uris); start += spotifyBatch {
+		end := min(start+spotifyBatch, len(uris))
+		body := map[string]any{"uris": uris[start:end]}
+		if err := c.do(ctx, http.MethodPost, "/playlists/"+playlist.ID+"/tracks", body, nil); err != nil {
+			return "", err
+		}
+	}
+	return playlist.ExternalURLs.Spotify, nil
+}
+
+func (c *spotifyClient) do(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, method, spotifyAPI+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			// Search bursts hit the rate limit quickly; honour Retry-After.
+			resp.Body.Close()
+			wait, _ := time.ParseDuration(resp.Header.Get("Retry-After") + "s")
+			time.Sleep(max(wait, time.Second))
+			continue
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("spotify %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// spotifyLogin runs the authorization-code flow with PKCE: the user opens
+// the printed URL, and the redirect lands on a temporary local listener.
+func spotifyLogin(ctx context.Context, clientID, redirect string) (string, error) {
+	redirectURL, err := url.Parse(redirect)
+	if err != nil {
+		return "", err
+	}
+
+	verifier := randomURLSafe(64)
+	sum := sha256.Sum256([]byte(verifier))
+	state := randomURLSafe(16)
+	authURL := spotifyAuthorize + "?" + url.Values{
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {redirect},
+		"code_challenge_method": {"S256"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"scope":                 {"playlist-modify-private playlist-modify-public"},
+		"state":                 {state},
+	}.Encode()
+
+	ln, err := net.Listen("tcp", redirectURL.Host)
+	if err != nil {
+		return "", err
+	}
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != redirectURL.Path {
+			http.NotFound(w, r)
+			return
+		}
+		// Only the first answer is waited for; later callbacks, such as a
+		// reloaded page, must not block on the full channels.
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			select {
+			case errs <- errors.New("OAuth state mismatch"):
+			default:
+			}
+		case q.Get("error") != "":
+			select {
+			case errs <- fmt.Errorf("authorization denied: %s", q.Get("error")):
+			default:
+			}
+		default:
+			select {
+			case codes <- q.Get("code"):
+			default:
+			}
+		}
+		fmt.Fprintln(w, "You can close this window and return to song-splitter.")
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	fmt.Printf("Open this URL to authorize song-splitter with Spotify:\n\n  %s\n\n", authURL)
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return "", err
+	case <-time.After(5 * time.Minute):
+		return "", errors.New("timed out waiting for Spotify authorization")
+	}
+
+	resp, err := http.PostForm(spotifyToken, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token exchange: %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
+
+func randomURLSafe(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)[:n]
+}
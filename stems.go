@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stemExtensions are the files a separator may write that are taken as stems.
+var stemExtensions = map[string]bool{".wav": true, ".flac": true, ".mp3": true, ".ogg": true, ".opus": true, ".m4a": true}
+
+// separateStems runs the --stems command on t's output, then encodes each
+// stem it wrote into a folder of that name, next to the output of the job
+// and of each of its siblings. Separators use every core they can get, so
+// the command runs with all other processes held back.
+func separateStems(ctx context.Context, t *Track, job *Job) error {
+	dir, err := os.MkdirTemp("", "song-splitter-stems-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	i := t.Number - 1
+	env := append(job.trackEnv(i, StatusRunning, nil), "SPLITTER_STEMS_DIR="+dir)
+	done, err := procs.acquireAll(ctx)
+	if err != nil {
+		return err
+	}
+	err = runHook(ctx, job.StemsCommand, env)
+	done()
+	if err != nil {
+		return err
+	}
+
+	stems, err := findStems(dir)
+	if err != nil {
+		return err
+	}
+	if len(stems) == 0 {
+		return fmt.Errorf("%q wrote no audio files to $SPLITTER_STEMS_DIR", job.StemsCommand)
+	}
+	names := make([]string, 0, len(stems))
+	for name := range stems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, j := range append([]*Job{job}, job.siblings...) {
+		var files []string
+		for _, name := range names {
+			out, err := encodeStem(ctx, &j.Tracks[i], j, name, stems[name])
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			files = append(files, out)
+		}
+		j.setStemFiles(i, files)
+	}
+	return nil
+}
+
+// findStems maps the name of each stem under dir to its file. Separators
+// nest their outputs in folders of their own, so dir is searched throughout.
+func findStems(dir string) (map[string]string, error) {
+	stems := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !stemExtensions[strings.ToLower(filepath.Ext(path))] {
+			return err
+		}
+		// A file named just ".wav" has no stem name to file it under.
+		name := stemName(strings.TrimSuffix(d.Name(), filepath.Ext(path)))
+		if _, ok := stems[name]; !ok && name != "" {
+			stems[name] = path
+		}
+		return nil
+	})
+	return stems, err
+}
+
+// stemName normalises a separator's name for a stem: Demucs calls the
+// instrumental "no_vocals" and Spleeter "accompaniment".
+func stemName(name string) string {
+	name = strings.ToLower(name)
+	switch name {
+	case "no_vocals", "accompaniment":
+		return "instrumental"
+	}
+	return name
+}
+
+// encodeStem encodes the stem in file into the job's format, tagged as the
+// track with the stem named in its title, and returns the output path.
+func encodeStem(ctx context.Context, t *Track, job *Job, name, file string) (string, error) {
+	label := strings.ToUpper(name[:1]) + name[1:]
+	base := filepath.Base(t.OutputFilename)
+	ext := filepath.Ext(base)
+	out := filepath.Join(job.OutputDir, name, strings.TrimSuffix(base, ext)+" ("+label+")"+ext)
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return "", err
+	}
+
+	args := []string{"-v", "warning", "-i", file, "-map", "0:a:0"}
+	if job.audioFormat() == FormatMP3 {
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+		args = append(args, id3Args(job)...)
+	} else {
+		args = append(args, audioCodecArgs(job, job.Preview > 0)...)
+	}
+	args = append(args, buildMetadata(t, job)...)
+	// The later title replaces the track's.
+	args = append(args, "-metadata", fmt.Sprintf("title=%s (%s)", buildTagTitle(t, job), label), "-y", out)
+
+	var output bytes.Buffer
+	if err := job.runTrackFFmpeg(ctx, t, runFFmpeg, &Command{Args: args, Stderr: &output}); err != nil {
+		return "", job.trackFFmpegError(ctx, t, err, output.Bytes())
+	}
+	return out, nil
+}
+
+func (j *Job) setStemFiles(i int, files []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stemFiles == nil {
+		j.stemFiles = make([][]string, len(j.Tracks))
+	}
+	j.stemFiles[i] = files
+}
+
+// StemFiles returns the stems written for track i.
+func (j *Job) StemFiles(i int) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if i < len(j.stemFiles) {
+		return j.stemFiles[i]
+	}
+	return nil
+}
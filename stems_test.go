@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindStems(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"htdemucs/Vocals.wav", "htdemucs/no_vocals.wav", ".wav", "notes.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := findStems(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"vocals":       filepath.Join(dir, "htdemucs/Vocals.wav"),
+		"instrumental": filepath.Join(dir, "htdemucs/no_vocals.wav"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("findStems() = %v, want %v", got, want)
+	}
+}
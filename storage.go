@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadAttempts is how often an upload is tried before the track fails.
+const uploadAttempts = 3
+
+// Storage is a remote destination for outputs.
+type Storage interface {
+	// Put uploads the local file to key, a slash-separated path below the
+	// destination's prefix.
+	Put(ctx context.Context, key, file string) error
+	// URL describes where key ends up, for logs and the manifest.
+	URL(key string) string
+}
+
+// openStorage parses an --upload destination:
+//
+//	s3://bucket/prefix        S3 or any S3-compatible service (B2, MinIO, R2)
+//	webdav://host/path        WebDAV over HTTPS; webdav+http:// for plain HTTP
+//
+// S3 credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// optionally AWS_SESSION_TOKEN; AWS_REGION defaults to us-east-1, and
+// AWS_ENDPOINT_URL points at a non-AWS service. WebDAV credentials are taken
+// from the URL's user info.
+func openStorage(raw string) (Storage, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload destination: %v", err)
+	}
+	client := &http.Client{Timeout: 30 * time.Minute}
+
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, errors.New("upload destination s3:// needs a bucket")
+		}
+		s := &s3Storage{
+			bucket: u.Host,
+			prefix: strings.Trim(u.Path, "/"),
+			region: os.Getenv("AWS_REGION"),
+			key:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			token:  os.Getenv("AWS_SESSION_TOKEN"),
+			http:   client,
+		}
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+		if s.key == "" || s.secret == "" {
+			return nil, errors.New("S3 upload needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		if ep := os.Getenv("AWS_ENDPOINT_URL"); ep != "" {
+			if s.endpoint, err = url.Parse(ep); err != nil {
+				return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL: %v", err)
+			}
+		}
+		return s, nil
+	case "webdav", "webdav+http":
+		base := *u
+		base.Scheme = "https"
+		if u.Scheme == "webdav+http" {
+			base.Scheme = "http"
+		}
+		base.User = nil
+		w := &webdavStorage{base: &base, http: client}
+		if u.User != nil {
+			w.user = u.User.Username()
+			w.pass, _ = u.User.Password()
+		}
+		return w, nil
+	default:
+		return nil, fmt.Errorf("unsupported upload destination %q: want s3:// or webdav://", raw)
+	}
+}
+
+// uploadFile puts file under key, retrying with backoff.
+func uploadFile(ctx context.Context, s Storage, key, file string) error {
+	var err error
+	for attempt := 1; attempt <= uploadAttempts; attempt++ {
+		if err = s.Put(ctx, key, file); err == nil {
+			return nil
+		}
+		if attempt < uploadAttempts {
+			select {
+			case <-time.After(time.Duration(1<<(attempt-1)) * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return fmt.Errorf("upload %s: %v", key, err)
+}
+
+// uploaded records what a job has uploaded, for the final manifest.
+type uploaded struct {
+	mu    sync.Mutex
+	files map[string]UploadEntry
+}
+
+type UploadEntry struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// uploadTrack uploads a finished track and its sidecar files.
+func (j *Job) uploadTrack(ctx context.Context, t *Track) error {
+	files := append([]string{t.OutputFilename}, trackSidecars(t)...)
+	for _, f := range append(files, j.StemFiles(t.Number-1)...) {
+		if err := j.upload(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *Job) upload(ctx context.Context, file string) error {
+	rel, err := filepath.Rel(j.OutputDir, file)
+	if err != nil {
+		return err
+	}
+	key := path.Join(j.uploadPrefix, filepath.ToSlash(rel))
+	if err := uploadFile(ctx, j.storage, key, file); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	sum, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	j.uploads.mu.Lock()
+	j.uploads.files[key] = UploadEntry{Key: key, URL: j.storage.URL(key), Size: info.Size(), SHA256: sum}
+	j.uploads.mu.Unlock()
+	return nil
+}
+
+// finishUploads uploads whatever the run left in the output directory that
+// is not up yet, such as checksums or the archive, and then a manifest.json
+// listing everything uploaded.
+func finishUploads(ctx context.Context, job *Job, logger *slog.Logger) error {
+	files, err := listFiles(job.OutputDir)
+	if err != nil {
+		return err
+	}
+	for _, rel := range files {
+		job.uploads.mu.Lock()
+		_, done := job.uploads.files[path.Join(job.uploadPrefix, filepath.ToSlash(rel))]
+		job.uploads.mu.Unlock()
+		if done {
+			continue
+		}
+		if err := job.upload(ctx, filepath.Join(job.OutputDir, rel)); err != nil {
+			return err
+		}
+	}
+
+	job.uploads.mu.Lock()
+	entries := make([]UploadEntry, 0, len(job.uploads.files))
+	for _, e := range job.uploads.files {
+		entries = append(entries, e)
+	}
+	job.uploads.mu.Unlock()
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Key < entries[k].Key })
+
+	manifest, err := json.MarshalIndent(map[string]any{
+		"album": job.Album,
+		"files": entries,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "song-splitter-manifest-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(manifest); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	key := path.Join(job.uploadPrefix, "manifest.json")
+	if err := uploadFile(ctx, job.storage, key, tmp.Name()); err != nil {
+		return err
+	}
+	logger.Info("Uploaded outputs", "manifest", job.storage.URL(key), "files", len(entries))
+	return nil
+}
+
+type s3Storage struct {
+	bucket, prefix string
+	region         string
+	key, secret    string
+	token          string
+	// endpoint is set for S3-compatible services, which are addressed
+	// path-style; AWS itself is addressed by virtual host.
+	endpoint *url.URL
+	http     *http.Client
+}
+
+func (s *s3Storage) objectURL(key string) *url.URL {
+	key = path.Join(s.prefix, key)
+	if s.endpoint != nil {
+		u := *s.endpoint
+		u.Path = path.Join("/", u.Path, s.bucket, key)
+		return &u
+	}
+	return &url.URL{
+		Scheme: "https",
+		Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region),
+		Path:   "/" + key,
+	}
+}
+
+func (s *s3Storage) URL(key string) string {
+	return "s3://" + path.Join(s.bucket, s.prefix, key)
+}
+
+func (s *s3Storage) Put(ctx context.Context, key, file string) error {
+	sum, err := hashFile(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	s.sign(req, sum, time.Now().UTC())
+	return doStorageRequest(s.http, req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header.
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonHeaders, "%s:%s\n", name, headers[name])
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", day, s.region)
+	canonSum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonSum[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.secret), day)
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.key, scope, signed, sig))
+	// The URL must be sent exactly as it was signed.
+	req.URL.RawPath = s3EscapePath(req.URL.Path)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath percent-encodes everything but unreserved characters and
+// slashes, as SigV4 requires.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+type webdavStorage struct {
+	base       *url.URL
+	user, pass string
+	http       *http.Client
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+func (w *webdavStorage) fileURL(key string) *url.URL {
+	u := *w.base
+	u.Path = path.Join("/", u.Path, key)
+	return &u
+}
+
+func (w *webdavStorage) URL(key string) string {
+	return w.fileURL(key).String()
+}
+
+func (w *webdavStorage) Put(ctx context.Context, key, file string) error {
+	if err := w.mkdirs(ctx, path.Dir(key)); err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, w.fileURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	w.auth(req)
+	return doStorageRequest(w.http, req)
+}
+
+// mkdirs creates every collection from the base URL, which must exist, down
+// to dir, remembering which exist.
+func (w *webdavStorage) mkdirs(ctx context.Context, dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.created == nil {
+		w.created = make(map[string]bool)
+	}
+
+	p := ""
+	for _, part := range strings.Split(dir, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		p = path.Join(p, part)
+		if w.created[p] {
+			continue
+		}
+		u := *w.fileURL(p)
+		u.Path += "/"
+		req, err := http.NewRequestWithContext(ctx, "MKCOL", u.String(), nil)
+		if err != nil {
+			return err
+		}
+		w.auth(req)
+		resp, err := w.http.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 means the collection already exists.
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("MKCOL %s: %s", u.Redacted(), resp.Status)
+		}
+		w.created[p] = true
+	}
+	return nil
+}
+
+func (w *webdavStorage) auth(req *http.Request) {
+	if w.user != "" {
+		req.SetBasicAuth(w.user, w.pass)
+	}
+}
+
+func doStorageRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mediaStream is an audio or video stream of the input, as ffprobe lists
+// it. Index counts streams of the same type, as in ffmpeg's "0:a:1".
+type mediaStream struct {
+	Type     string
+	Index    int
+	Codec    string
+	Channels int
+	Language string
+	Title    string
+}
+
+// probeStreams lists the input's audio and video streams. Attached
+// pictures such as cover art are left out, though they still count towards
+// the video index.
+func probeStreams(input string) ([]mediaStream, error) {
+	args := []string{"-v", "error", "-show_entries",
+		"stream=codec_type,codec_name,channels:stream_tags=language,title:stream_disposition=attached_pic", "-of", "json"}
+	output, err := executor.Probe(context.Background(), append(args, inputArgs(input)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v", err)
+	}
+	var probe struct {
+		Streams []struct {
+			CodecType   string            `json:"codec_type"`
+			CodecName   string            `json:"codec_name"`
+			Channels    int               `json:"channels"`
+			Tags        map[string]string `json:"tags"`
+			Disposition map[string]int    `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %v", err)
+	}
+
+	var streams []mediaStream
+	counts := make(map[string]int)
+	for _, s := range probe.Streams {
+		if s.CodecType != "audio" && s.CodecType != "video" {
+			continue
+		}
+		index := counts[s.CodecType]
+		counts[s.CodecType]++
+		if s.Disposition["attached_pic"] == 1 {
+			continue
+		}
+		streams = append(streams, mediaStream{
+			Type: s.CodecType, Index: index, Codec: s.CodecName, Channels: s.Channels,
+			Language: s.Tags["language"], Title: s.Tags["title"],
+		})
+	}
+	return streams, nil
+}
+
+// selectStream picks the stream of the given type named by sel: its index
+// among the streams of that type, or its language tag, such as "eng". An
+// empty sel picks the first.
+func selectStream(streams []mediaStream, kind, sel string) (mediaStream, error) {
+	var candidates []mediaStream
+	for _, s := range streams {
+		if s.Type == kind {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		return mediaStream{}, fmt.Errorf("the input has no %s stream", kind)
+	}
+	if sel == "" {
+		return candidates[0], nil
+	}
+
+	if n, err := strconv.Atoi(sel); err == nil {
+		for _, s := range candidates {
+			if s.Index == n {
+				return s, nil
+			}
+		}
+		return mediaStream{}, fmt.Errorf("no %s stream %d: the input has %s", kind, n, describeStreams(candidates))
+	}
+	for _, s := range candidates {
+		if strings.EqualFold(s.Language, sel) {
+			return s, nil
+		}
+	}
+	return mediaStream{}, fmt.Errorf("no %s stream in language %q: the input has %s", kind, sel, describeStreams(candidates))
+}
+
+// describeStreams is a short list of streams for error messages.
+func describeStreams(streams []mediaStream) string {
+	parts := make([]string, len(streams))
+	for i, s := range streams {
+		parts[i] = s.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (s mediaStream) String() string {
+	desc := fmt.Sprintf("%s %d (%s", s.Type, s.Index, s.Codec)
+	if s.Channels > 0 {
+		desc += fmt.Sprintf(", %d channels", s.Channels)
+	}
+	if s.Language != "" {
+		desc += ", " + s.Language
+	}
+	if s.Title != "" {
+		desc += fmt.Sprintf(", %q", s.Title)
+	}
+	return desc + ")"
+}
+
+// audioMap and videoMap are the ffmpeg stream specifiers of the selected
+// streams of the input.
+func (j *Job) audioMap() string {
+	return fmt.Sprintf("0:a:%d", j.audioStream.Index)
+}
+
+func (j *Job) videoMap() string {
+	return fmt.Sprintf("0:v:%d", j.videoStream.Index)
+}
+
+// hasStreamChoice reports whether the input has more than one stream of a
+// type, so which was taken is worth listing.
+func (j *Job) hasStreamChoice() bool {
+	counts := make(map[string]int)
+	for _, s := range j.streams {
+		counts[s.Type]++
+	}
+	return counts["audio"] > 1 || counts["video"] > 1
+}
+
+// selectsStreams reports whether a stream was chosen by flag, so that video
+// outputs need it mapped rather than left to ffmpeg's default choice.
+func (j *Job) selectsStreams() bool {
+	return j.AudioStream != "" || j.VideoStream != ""
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+// benchmarkSeconds is the length of the sample encoded to estimate the size
+// and encode time of a job.
+const benchmarkSeconds = 10.0
+
+// jobEstimate is what the sample encode predicts for one output folder.
+type jobEstimate struct {
+	// Bytes is the expected total size of the outputs, or 0 if unknown.
+	Bytes float64
+	// Time is the expected encode time, shared with the job's siblings.
+	Time time.Duration
+	// Speed is how many times faster than real time the sample encoded.
+	Speed float64
+}
+
+// benchmarkJob encodes a sample from the middle of the job's longest track,
+// with its own and its siblings' settings, and extrapolates the size of
+// every output and the time to encode them all.
+func benchmarkJob(ctx context.Context, job *Job) (map[*Job]jobEstimate, error) {
+	longest := &job.Tracks[0]
+	for i := range job.Tracks {
+		if t := &job.Tracks[i]; t.EndTime-t.StartTime > longest.EndTime-longest.StartTime {
+			longest = t
+		}
+	}
+	start, length := job.clipRange(longest)
+	sample := min(benchmarkSeconds, length)
+	start += (length - sample) / 2
+
+	dir, err := os.MkdirTemp("", "song-splitter-benchmark-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	// Sibling folders are named after their formats, so their bases differ.
+	out := func(j *Job) string {
+		return filepath.Join(dir, filepath.Base(j.OutputDir)+j.Ext)
+	}
+
+	began := time.Now()
+	if output, err := ffmpegOutput(ctx, encodeArgs(longest, job, start, sample, out)...); err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v\n%s", err, string(output))
+	}
+	elapsed := time.Since(began)
+
+	var total float64
+	for i := range job.Tracks {
+		_, l := job.clipRange(&job.Tracks[i])
+		total += l
+	}
+	// Tracks are encoded maxWorkers at a time.
+	workers := float64(min(maxWorkers, len(job.Tracks)))
+	est := jobEstimate{
+		Speed: sample / elapsed.Seconds(),
+		Time:  time.Duration(float64(elapsed) * total / sample / workers),
+	}
+
+	estimates := make(map[*Job]jobEstimate)
+	for _, j := range append([]*Job{job}, job.siblings...) {
+		e := est
+		if fi, err := os.Stat(out(j)); err == nil {
+			e.Bytes = float64(fi.Size()) * total / sample
+		}
+		estimates[j] = e
+	}
+	return estimates, nil
+}
+
+// writeSummary describes the planned jobs before anything is encoded: the
+// tracks and their lengths, then each output folder and its format. With
+// estimate, a sample encode adds each folder's estimated size and encode time.
+func writeSummary(ctx context.Context, w io.Writer, planned, jobs []*Job, estimate bool) {
+	for _, job := range planned {
+		var total float64
+		for _, t := range job.Tracks {
+			total += t.EndTime - t.StartTime
+		}
+		fmt.Fprintf(w, "%s: %d tracks, %s in total, %s on average\n",
+			job.Album, len(job.Tracks), formatTimestamp(total), formatTimestamp(total/float64(len(job.Tracks))))
+	}
+	if !estimate {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "\nOutput\tFormat\t")
+		for _, job := range jobs {
+			fmt.Fprintf(tw, "%s\t%s\t\n", job.OutputDir, summaryFormat(job))
+		}
+		tw.Flush()
+		return
+	}
+
+	estimates := make(map[*Job]jobEstimate)
+	var benchErr error
+	for _, job := range jobs {
+		if !job.encodes() || job.encodedBy != nil {
+			continue
+		}
+		e, err := benchmarkJob(ctx, job)
+		if err != nil {
+			benchErr = err
+			continue
+		}
+		for j, est := range e {
+			estimates[j] = est
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nOutput\tFormat\tSize\tTime\t")
+	var bytes float64
+	var elapsed time.Duration
+	for _, job := range jobs {
+		format := summaryFormat(job)
+		size, took := "?", "?"
+		if e, ok := estimates[job]; ok {
+			if e.Bytes > 0 {
+				size = formatSize(e.Bytes)
+				bytes += e.Bytes
+			}
+			if job.encodedBy == nil {
+				took = fmt.Sprintf("%s (%.0fx real time)", e.Time.Round(time.Second), e.Speed)
+				elapsed += e.Time
+			} else {
+				took = "with " + strings.TrimPrefix(job.encodedBy.Ext, ".")
+			}
+		} else if !job.encodes() {
+			size, took = "-", "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", job.OutputDir, format, size, took)
+	}
+	tw.Flush()
+	if bytes > 0 {
+		fmt.Fprintf(w, "\nEstimated total: about %s in %s\n", formatSize(bytes), elapsed.Round(time.Second))
+	}
+	if benchErr != nil {
+		fmt.Fprintf(w, "Could not encode a sample to estimate size and time: %v\n", benchErr)
+	}
+}
+
+// summaryFormat is the format column of the summary.
+func summaryFormat(job *Job) string {
+	if !job.encodes() {
+		return "analysis"
+	}
+	return strings.TrimPrefix(job.Ext, ".")
+}
+
+// formatSize is a byte count in the largest unit that keeps it above 1.
+func formatSize(b float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for b >= 1000 && i < len(units)-1 {
+		b /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", b, units[i])
+}
+
+// confirmSummary asks whether to go ahead after the summary, for --confirm.
+// Without a terminal to ask on it goes ahead, as scripts expect.
+func confirmSummary() error {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return nil
+	}
+	fmt.Print("Start splitting? (y/n): ")
+	var response string
+	fmt.Scanln(&response)
+	if strings.ToLower(response) != "y" {
+		return errors.New("user cancelled operation")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// opusLayouts are the layouts libopus encodes, in the Vorbis channel order
+// it expects. Other layouts, such as 5.1(side), are remapped to the nearest
+// of them.
+const opusLayouts = "mono|stereo|3.0|quad|5.0|5.1|6.1|7.1"
+
+const (
+	// surroundBitrateMP4 is the E-AC-3 bitrate of surround MP4 audio.
+	surroundBitrateMP4 = "640k"
+	// opusChannelBitrate is the Opus bitrate per channel of surround
+	// audio, which keeps 5.1 at about what stereo gets per channel.
+	opusChannelBitrate = 64
+)
+
+// keepChannels resolves --keep-channels for a source with the given number
+// of channels: it checks the option against the rest and leaves the channel
+// layout alone.
+func keepChannels(opts *Options, channels int) error {
+	if opts.Channels != "" && opts.Channels != ChannelsKeep {
+		return fmt.Errorf("--keep-channels cannot be combined with --channels %s", opts.Channels)
+	}
+	opts.Channels = ChannelsKeep
+	if channels <= 2 {
+		return nil
+	}
+	audio, _ := opts.formats()
+	if opts.Audio && slices.Contains(audio, FormatMP3) {
+		return fmt.Errorf("the input has %d audio channels, which MP3 cannot hold: use --format flac or opus with --keep-channels", channels)
+	}
+	if channels > 8 {
+		return fmt.Errorf("the input has %d audio channels; at most 8 (7.1) can be kept", channels)
+	}
+	return nil
+}
+
+// surround reports whether the job keeps more than two channels.
+func (j *Job) surround() bool {
+	return j.KeepChannels && j.SourceChannels > 2
+}
+
+// opusBitrate is the Opus bitrate of the job's audio, scaled up for
+// surround.
+func opusBitrate(job *Job, preview bool) string {
+	switch {
+	case preview:
+		return "64k"
+	case job.surround():
+		return fmt.Sprintf("%dk", opusChannelBitrate*job.SourceChannels)
+	}
+	return "160k"
+}
+
+// mp4AudioArgs encode the audio of MP4 outputs: AAC, or E-AC-3 for
+// surround, which players handle far more widely than multichannel AAC. The
+// encoder stops at 5.1, so 7.1 sources are downmixed to that.
+func mp4AudioArgs(job *Job, bitrate string) []string {
+	if job.surround() {
+		return []string{"-c:a", "eac3", "-b:a", surroundBitrateMP4}
+	}
+	return []string{"-c:a", "aac", "-b:a", bitrate}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tagCommand rewrites the tags of an already split directory from the
+// tracklist, overrides and tag flags, copying the streams rather than
+// encoding them again, and renames the files to match.
+func tagCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	var o Options
+	dir := flags.String("dir", "", "Directory of split tracks to retag, such as output or output/flac")
+	flags.StringVar(&o.Tracklist, "tracklist", "", "Tracklist the tracks were split from, corrected as needed")
+	flags.StringVar(&o.Overrides, "overrides", "", "JSON file of per-track corrections keyed by track number or title")
+	dryRun := flags.Bool("dry-run", false, "List the changes without writing anything")
+	bindTagFlags(flags, &o)
+	return func(logger *slog.Logger) error {
+		if *dir == "" || o.Tracklist == "" {
+			return errors.New("both --dir and --tracklist are required")
+		}
+		job, err := planTags(o)
+		if err != nil {
+			return err
+		}
+		files, err := numberedFiles(*dir)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("no split tracks found in %s", *dir)
+		}
+		job.OutputDir = *dir
+		if job.Lyrics != "" && !*dryRun {
+			fetchLyrics(context.Background(), job, logger)
+		}
+
+		numbers := make([]int, 0, len(files))
+		for n := range files {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+
+		retagged, failed := 0, 0
+		for _, n := range numbers {
+			paths := files[n]
+			if n < 1 || n > len(job.Tracks) {
+				logger.Warn("No track in the tracklist for file", "files", paths)
+				continue
+			}
+			for _, path := range paths {
+				ext := filepath.Ext(path)
+				fj := job.forExtension(ext, *dir)
+				t := &fj.Tracks[n-1]
+				if *dryRun {
+					fmt.Printf("%s -> %s: %s - %s\n", filepath.Base(path), filepath.Base(t.OutputFilename), t.MainArtist, buildTagTitle(t, fj))
+					continue
+				}
+				if err := retagFile(context.Background(), path, t, fj); err != nil {
+					logger.Error("Retagging failed", "file", path, "error", err)
+					failed++
+					continue
+				}
+				retagged++
+			}
+		}
+		for i := range job.Tracks {
+			if _, ok := files[i+1]; !ok {
+				logger.Warn("No file found for track", "track", i+1, "title", job.Tracks[i].MainTitle)
+			}
+		}
+		if *dryRun {
+			return nil
+		}
+
+		logger.Info("Retagged tracks", "dir", *dir, "files", retagged, "failed", failed)
+		if sums, _ := filepath.Glob(filepath.Join(*dir, "*.sha256")); len(sums) > 0 || fileExists(filepath.Join(*dir, checksumManifest)) {
+			logger.Warn("The recorded checksums no longer match the retagged files", "dir", *dir)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d files could not be retagged", failed)
+		}
+		return nil
+	}
+}
+
+// planTags plans the tracks of a split from the tracklist alone. The input
+// only decides where tracks start and end, which their tags and filenames
+// do not depend on.
+func planTags(opts Options) (*Job, error) {
+	f, err := os.Open(opts.Tracklist)
+	if err != nil {
+		return nil, err
+	}
+	tracks, album, err := parseTracklist(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("parse tracklist: %v", err)
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("tracklist contains no tracks")
+	}
+	// Untimed tracks only need to stay in place when overrides re-sort the
+	// tracks, so any length past the last timestamp will do.
+	var last float64
+	for _, t := range tracks {
+		last = max(last, t.StartTime)
+	}
+	if err := interpolateStarts(tracks, last+float64(len(tracks))); err != nil {
+		return nil, err
+	}
+	if !validSegmentsMode(opts.Segments) {
+		return nil, fmt.Errorf("invalid segments mode %q: want exclude, bonus or include", opts.Segments)
+	}
+	tracks = applySegments(tracks, opts.Segments)
+	if opts.Overrides != "" {
+		overrides, err := loadOverrides(opts.Overrides)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyOverrides(tracks, numberedTracks(tracks, opts.Segments, opts.Duplicates), overrides); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Album != "" {
+		album = opts.Album
+	}
+	if err := checkTagOptions(&opts); err != nil {
+		return nil, err
+	}
+	// Merging duplicate starts changes the numbering.
+	tracks = calculateEndTimes(tracks, 0, opts.Duplicates)
+	if err := opts.Filenames.validate(); err != nil {
+		return nil, err
+	}
+	for i := range tracks {
+		tracks[i].Number = i + 1
+	}
+	return &Job{Options: opts, Album: album, Tracks: tracks}, nil
+}
+
+// forExtension is the job as the split that wrote files with extension ext
+// into dir saw it.
+func (j *Job) forExtension(ext, dir string) *Job {
+	o := j.Options
+	o.Format = strings.TrimPrefix(ext, ".")
+	o.Audio, o.Video = true, false
+	if ext == ".mp4" || ext == ".webm" {
+		o.Audio, o.Video = false, true
+	}
+	job := &Job{Options: o, Album: j.Album, Tracks: append([]Track(nil), j.Tracks...), Ext: ext}
+	createFilenames(job.Tracks, dir, ext, job.Filenames)
+	return job
+}
+
+// numberedFiles finds the split tracks in dir by the number their names
+// start with.
+func numberedFiles(dir string) (map[int][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[int][]string)
+	for _, e := range entries {
+		m := numberPrefixRe.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil || !mergeExtensions[strings.ToLower(filepath.Ext(e.Name()))] {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		files[n] = append(files[n], filepath.Join(dir, e.Name()))
+	}
+	return files, nil
+}
+
+// retagFile writes t's tags, and its artwork if it has one, to the file at
+// path by copying its streams, then renames it and its sidecar files to t's
+// output filename. Tags the split wrote that are not written again, such as
+// ReplayGain, are kept.
+func retagFile(ctx context.Context, path string, t *Track, job *Job) error {
+	tmp := filepath.Join(filepath.Dir(path), ".retag-"+filepath.Base(path))
+	args := []string{"-v", "warning", "-i", path}
+	if t.Artwork != "" && job.canEmbedArtwork() {
+		args = append(args, "-i", t.Artwork)
+		args = append(args, artworkArgs(job)...)
+	} else {
+		args = append(args, "-map", "0")
+	}
+	args = append(args, "-c", "copy")
+	switch job.Ext {
+	case ".mp3":
+		args = append(args, id3Args(job)...)
+	case ".mp4", ".m4a":
+		args = append(args, "-movflags", "+faststart+use_metadata_tags")
+	}
+	args = append(args, buildMetadata(t, job)...)
+	args = append(args, "-y", tmp)
+
+	var output bytes.Buffer
+	if err := runFFmpeg(ctx, &Command{Args: args, Stderr: &output}); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ffmpeg error: %v\n%s", err, output.String())
+	}
+	if err := os.Rename(tmp, t.OutputFilename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if t.OutputFilename != path {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		old := Track{OutputFilename: path}
+		for _, suffix := range sidecarSuffixes {
+			if from := sidecarPath(&old, suffix); fileExists(from) {
+				if err := os.Rename(from, sidecarPath(t, suffix)); err != nil {
+					return err
+				}
+			}
+		}
+		if fileExists(path + ".sha256") {
+			if err := os.Rename(path+".sha256", t.OutputFilename+".sha256"); err != nil {
+				return err
+			}
+		}
+	}
+	if job.lrcLyrics() {
+		return writeLRC(t)
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Track struct {
+	// Number is the track's 1-based position in the output set.
+	Number         int
+	StartTime      float64
+	EndTime        float64
+	MainArtist     string
+	MainTitle      string
+	MainLabel      string
+	Credits        Credits
+	Additional     []AdditionalTrack
+	OutputFilename string
+	// Artwork is an image embedded as the track's cover.
+	Artwork string
+	// Gain is a volume adjustment in dB applied while encoding.
+	Gain float64
+	// Drift is how far --video-copy moved the start to reach a keyframe.
+	Drift float64
+	// Transform crops, rotates and scales the track's video.
+	Transform VideoTransform
+	// Ends trims where the track ends.
+	Ends EndRules
+	// Merged are the tracklist lines of tracks starting with this one,
+	// merged into it as w/ tracks.
+	Merged []int
+	// Lyrics and SyncedLyrics (LRC) are filled in by --lyrics.
+	Lyrics       string
+	SyncedLyrics string
+	// Line is the tracklist line the track came from, or 0 if it did not
+	// come from one, and Timestamp its start time as written there.
+	Line      int
+	Timestamp string
+	// Estimated marks a start time the tracklist did not give, filled in
+	// by interpolateStarts.
+	Estimated bool
+	// Detected marks a start time found by --detect-boundaries instead,
+	// with Confidence from 0 to 1. One found with low confidence is still
+	// Estimated.
+	Detected   bool
+	Confidence float64
+	// Segment is the kind of a non-music line such as "MC" or "On Stage",
+	// whose text is kept as MainTitle. It is empty for music.
+	Segment string
+}
+
+type AdditionalTrack struct {
+	Artist  string
+	Title   string
+	Label   string
+	Credits Credits
+}
+
+// Credits breaks an "A b2b B ft. C" style artist string, plus any "(feat. X)"
+// or "(X Remix)" in the title, into individual names for tagging. The
+// original strings are kept untouched for display and filenames.
+type Credits struct {
+	// Primary is the artist credit without its featured artists.
+	Primary  string
+	Artists  []string
+	Featured []string
+	Remixers []string
+	// Version is a trailing "(Extended Mix)"-style suffix of the title, and
+	// BaseTitle the title without it.
+	Version   string
+	BaseTitle string
+}
+
+var (
+	// collabRe separates artists performing together. "&" and "x" are left
+	// alone since they are usually part of an act's name.
+	collabRe    = regexp.MustCompile(`(?i)\s+(?:b2b|b3b|vs\.?)\s+`)
+	featRe      = regexp.MustCompile(`(?i)\s+(?:ft\.?|feat\.?|featuring)\s+(.+)$`)
+	titleFeatRe = regexp.MustCompile(`(?i)[(\[](?:ft\.?|feat\.?|featuring)\s+([^)\]]+)[)\]]`)
+	// versionRe matches a trailing bracketed mix/edit description.
+	versionRe = regexp.MustCompile(`(?i)\s*[(\[]([^()\[\]]*\b(?:mix|remix|rmx|edit|rework|bootleg|flip|vip|version|dub|mashup|remode)\b[^()\[\]]*)[)\]]\s*$`)
+	// remixByRe matches versions that credit someone: "X Remix", "X Edit".
+	remixByRe = regexp.MustCompile(`(?i)^(.+?)\s+(?:remix|rmx|edit|rework|bootleg|flip|remode)$`)
+	// nameListRe splits a list of featured artists or remixers.
+	nameListRe = regexp.MustCompile(`\s*,\s*|\s+&\s+|\s+and\s+`)
+)
+
+func parseTracklist(r io.Reader) ([]Track, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan()
+	header := strings.TrimSpace(scanner.Text())
+	lineNo := 1
+
+	var tracks []Track
+	currentTrack := (*Track)(nil)
+	lineRe := regexp.MustCompile(`^\[(\d+(?::\d+){1,3}|\?\?(?::\?\?){1,2})\]\s(.+?)(?:\s\[(.+)\])?$`)
+	wRe := regexp.MustCompile(`^w/\s(.+?)(?:\s\[(.+)\])?$`)
+	// untimedRe matches an "Artist - Title [Label]" line with no timestamp.
+	untimedRe := regexp.MustCompile(`^([^\[].*?\s-\s.+?)(?:\s\[(.+)\])?$`)
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		matches := lineRe.FindStringSubmatch(line)
+		if matches == nil && !strings.HasPrefix(line, "w/") {
+			if m := untimedRe.FindStringSubmatch(line); m != nil {
+				matches = []string{line, "", m[1], m[2]}
+			}
+		}
+
+		if matches != nil {
+			if currentTrack != nil {
+				tracks = append(tracks, *currentTrack)
+			}
+
+			// Lines with "[??:??]" or no timestamp get one estimated
+			// later by interpolateStarts.
+			start, stamp, estimated := 0.0, "", true
+			if matches[1] != "" && !strings.HasPrefix(matches[1], "?") {
+				var err error
+				if start, err = parseTimestamp(matches[1]); err != nil {
+					return nil, "", err
+				}
+				stamp, estimated = matches[1], false
+			}
+
+			// Announcements and breaks are kept as segments so their start
+			// bounds the track before; applySegments decides what becomes
+			// of them.
+			if kind, ok := parseSegment(matches[2]); ok {
+				currentTrack = &Track{
+					StartTime: start,
+					MainTitle: matches[2],
+					Line:      lineNo,
+					Timestamp: stamp,
+					Estimated: estimated,
+					Segment:   kind,
+				}
+				continue
+			}
+
+			artist, title, credits, err := parseArtistTitle(matches[2])
+			if err != nil {
+				return nil, "", err
+			}
+
+			label := ""
+			if len(matches) > 3 && matches[3] != "" {
+				label = matches[3]
+			}
+
+			currentTrack = &Track{
+				StartTime:  start,
+				MainArtist: artist,
+				MainTitle:  title,
+				MainLabel:  label,
+				Credits:    credits,
+				Line:       lineNo,
+				Timestamp:  stamp,
+				Estimated:  estimated,
+			}
+		} else if strings.HasPrefix(line, "w/") {
+			if currentTrack == nil || currentTrack.Segment != "" {
+				continue
+			}
+
+			matches := wRe.FindStringSubmatch(line)
+			if matches == nil {
+				continue
+			}
+
+			artist, title, credits, err := parseArtistTitle(matches[1])
+			if err != nil {
+				return nil, "", err
+			}
+
+			currentTrack.Additional = append(currentTrack.Additional, AdditionalTrack{
+				Artist:  artist,
+				Title:   title,
+				Label:   matches[2],
+				Credits: credits,
+			})
+		}
+	}
+
+	if currentTrack != nil {
+		tracks = append(tracks, *currentTrack)
+	}
+
+	return tracks, header, scanner.Err()
+}
+
+// writeTracklist renders tracks in the format parseTracklist reads.
+func writeTracklist(w io.Writer, header string, tracks []Track) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, header)
+	for _, t := range tracks {
+		ts := formatTimestamp(t.StartTime)
+		if t.Estimated {
+			ts = "??:??"
+		}
+		if t.Segment != "" {
+			fmt.Fprintf(bw, "[%s] %s\n", ts, t.MainTitle)
+			continue
+		}
+		fmt.Fprintf(bw, "[%s] %s - %s", ts, t.MainArtist, t.MainTitle)
+		if t.MainLabel != "" {
+			fmt.Fprintf(bw, " [%s]", t.MainLabel)
+		}
+		fmt.Fprintln(bw)
+		for _, add := range t.Additional {
+			fmt.Fprintf(bw, "w/ %s - %s", add.Artist, add.Title)
+			if add.Label != "" {
+				fmt.Fprintf(bw, " [%s]", add.Label)
+			}
+			fmt.Fprintln(bw)
+		}
+	}
+	return bw.Flush()
+}
+
+// parseTimestamp reads MM:SS, HH:MM:SS or D:HH:MM:SS. The first field is not
+// capped, so the timeline of a multi-day recording can run past 23:59:59
+// either as 26:00:00 or as 1:02:00:00.
+func parseTimestamp(ts string) (float64, error) {
+	parts := strings.Split(ts, ":")
+	var total float64
+
+	multipliers := []float64{1, 60, 3600, 86400}
+	if len(parts) > len(multipliers) {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	for i := range parts {
+		val, err := strconv.Atoi(parts[len(parts)-1-i])
+		if err != nil {
+			return 0, err
+		}
+		total += float64(val) * multipliers[i]
+	}
+	return total, nil
+}
+
+// parseClock reads a time of day, HH:MM or HH:MM:SS, as seconds since
+// midnight.
+func parseClock(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time of day %q: want HH:MM or HH:MM:SS", s)
+	}
+	limits, units := []int{24, 60, 60}, []float64{3600, 60, 1}
+	var total float64
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n >= limits[i] {
+			return 0, fmt.Errorf("invalid time of day %q: want HH:MM or HH:MM:SS", s)
+		}
+		total += float64(n) * units[i]
+	}
+	return total, nil
+}
+
+// clockStarts reads the timestamps of tracks as times of day, as radio cue
+// logs give them, and makes them offsets into a recording begun at the time
+// of day start. They run in order, so a time more than 12 hours before the
+// one above it is on the next day, as 00:15 after 23:47. A track already
+// playing when the recording began starts with it.
+func clockStarts(tracks []Track, start string) error {
+	begin, err := parseClock(start)
+	if err != nil {
+		return fmt.Errorf("--recording-start: %v", err)
+	}
+	day, prev := 0.0, begin
+	for i := range tracks {
+		t := &tracks[i]
+		if t.Timestamp == "" {
+			continue
+		}
+		clock, err := parseClock(t.Timestamp)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", t.Line, err)
+		}
+		at := clock + day
+		if at < prev-12*3600 {
+			day += 24 * 3600
+			at += 24 * 3600
+		}
+		t.StartTime = max(at-begin, 0)
+		prev = at
+	}
+	return nil
+}
+
+// formatTimestamp is the inverse of parseTimestamp, rendering seconds as
+// H:MM:SS for display.
+func formatTimestamp(secs float64) string {
+	s := int(secs)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+func parseArtistTitle(s string) (string, string, Credits, error) {
+	parts := strings.SplitN(s, " - ", 2)
+	if len(parts) != 2 {
+		return "", "", Credits{}, fmt.Errorf("invalid artist/title format: %s", s)
+	}
+	return parts[0], parts[1], parseCredits(parts[0], parts[1]), nil
+}
+
+func parseCredits(artist, title string) Credits {
+	var c Credits
+
+	c.Primary = artist
+	if m := featRe.FindStringSubmatchIndex(artist); m != nil {
+		c.Primary = artist[:m[0]]
+		c.Featured = splitNames(artist[m[2]:m[3]])
+	}
+	c.Artists = collabRe.Split(c.Primary, -1)
+
+	for _, m := range titleFeatRe.FindAllStringSubmatch(title, -1) {
+		c.Featured = append(c.Featured, splitNames(m[1])...)
+	}
+
+	c.BaseTitle = title
+	if m := versionRe.FindStringSubmatchIndex(title); m != nil {
+		c.BaseTitle = title[:m[0]]
+		c.Version = title[m[2]:m[3]]
+		if r := remixByRe.FindStringSubmatch(c.Version); r != nil && !isGenericVersion(r[1]) {
+			c.Remixers = splitNames(r[1])
+		}
+	}
+	return c
+}
+
+// genericVersionWords describe a kind of edit rather than who made it, as in
+// "Festival Edit" or "Extended Club Mix".
+var genericVersionWords = map[string]bool{
+	"extended": true, "original": true, "radio": true, "club": true,
+	"festival": true, "dub": true, "vip": true, "instrumental": true,
+	"intro": true, "outro": true, "short": true, "long": true, "live": true,
+	"acapella": true, "acappella": true, "mashup": true, "special": true,
+	"re": true, "single": true, "album": true,
+}
+
+func isGenericVersion(s string) bool {
+	for _, w := range strings.Fields(strings.ToLower(s)) {
+		if !genericVersionWords[strings.Trim(w, "-")] {
+			return false
+		}
+	}
+	return true
+}
+
+func splitNames(s string) []string {
+	var names []string
+	for _, n := range nameListRe.Split(strings.TrimSpace(s), -1) {
+		if n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// AllArtists lists the primary artists followed by the featured ones.
+func (c Credits) AllArtists() []string {
+	return append(append([]string(nil), c.Artists...), c.Featured...)
+}
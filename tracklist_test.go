@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "00:00", want: 0},
+		{in: "03:25", want: 205},
+		{in: "75:00", want: 4500},
+		{in: "1:02:03", want: 3723},
+		{in: "26:15:00", want: 94500},
+		{in: "1:02:15:00", want: 94500},
+		{in: "1:2:3:4:5", wantErr: true},
+		{in: "1:xx", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "00:00", want: 0},
+		{in: "22:05", want: 79500},
+		{in: "23:59:59", want: 86399},
+		{in: "24:00", wantErr: true},
+		{in: "12:60", wantErr: true},
+		{in: "12:30:60", wantErr: true},
+		{in: "12", wantErr: true},
+		{in: "1:02:03:04", wantErr: true},
+		{in: "-1:00", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseClock(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseClock(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClockStarts(t *testing.T) {
+	tests := []struct {
+		name       string
+		start      string
+		timestamps []string
+		want       []float64
+		wantErr    bool
+	}{
+		{
+			name:       "same day",
+			start:      "22:00",
+			timestamps: []string{"22:00", "22:05", "22:47:30"},
+			want:       []float64{0, 300, 2850},
+		},
+		{
+			name:       "past midnight",
+			start:      "22:00",
+			timestamps: []string{"23:47", "00:15", "01:00"},
+			want:       []float64{6420, 8100, 10800},
+		},
+		{
+			name:       "playing before the recording began",
+			start:      "22:00",
+			timestamps: []string{"21:58", "22:03"},
+			want:       []float64{0, 180},
+		},
+		{
+			name:       "untimed tracks are left alone",
+			start:      "10:00",
+			timestamps: []string{"10:00", "", "10:30"},
+			want:       []float64{0, 0, 1800},
+		},
+		{name: "bad start", start: "25:00", timestamps: []string{"10:00"}, wantErr: true},
+		{name: "bad timestamp", start: "10:00", timestamps: []string{"10:61"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := make([]Track, len(tt.timestamps))
+			for i, ts := range tt.timestamps {
+				tracks[i] = Track{Line: i + 1, Timestamp: ts}
+			}
+			err := clockStarts(tracks, tt.start)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clockStarts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			for i, tr := range tracks {
+				if tr.StartTime != tt.want[i] {
+					t.Errorf("track %d starts at %v, want %v", i+1, tr.StartTime, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCredits(t *testing.T) {
+	tests := []struct {
+		artist, title string
+		want          Credits
+	}{
+		{
+			artist: "Artist", title: "Song",
+			want: Credits{Primary: "Artist", Artists: []string{"Artist"}, BaseTitle: "Song"},
+		},
+		{
+			artist: "A b2b B ft. C & D", title: "Song",
+			want: Credits{Primary: "A b2b B", Artists: []string{"A", "B"}, Featured: []string{"C", "D"}, BaseTitle: "Song"},
+		},
+		{
+			artist: "Artist", title: "Song (feat. Singer) (Someone Remix)",
+			want: Credits{
+				Primary: "Artist", Artists: []string{"Artist"}, Featured: []string{"Singer"},
+				Remixers: []string{"Someone"}, Version: "Someone Remix", BaseTitle: "Song (feat. Singer)",
+			},
+		},
+		{
+			artist: "Artist", title: "Song (Extended Mix)",
+			want: Credits{Primary: "Artist", Artists: []string{"Artist"}, Version: "Extended Mix", BaseTitle: "Song"},
+		},
+		{
+			artist: "Artist", title: "Song [Festival Edit]",
+			want: Credits{Primary: "Artist", Artists: []string{"Artist"}, Version: "Festival Edit", BaseTitle: "Song"},
+		},
+		{
+			artist: "Artist", title: "Song (X, Y and Z Remix)",
+			want: Credits{Primary: "Artist", Artists: []string{"Artist"}, Remixers: []string{"X", "Y", "Z"}, Version: "X, Y and Z Remix", BaseTitle: "Song"},
+		},
+		{
+			artist: "Simon & Garfunkel", title: "Song",
+			want: Credits{Primary: "Simon & Garfunkel", Artists: []string{"Simon & Garfunkel"}, BaseTitle: "Song"},
+		},
+	}
+	for _, tt := range tests {
+		if got := parseCredits(tt.artist, tt.title); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCredits(%q, %q) = %+v, want %+v", tt.artist, tt.title, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// VideoTransform crops, rotates and scales the video of an output, in that
+// order. The zero value leaves it as it is.
+type VideoTransform struct {
+	// Crop is "W:H" or "W:H:X:Y" in pixels of the source; without X and Y
+	// the crop is centred.
+	Crop string
+	// Rotate is clockwise, in degrees: 0, 90, 180 or 270.
+	Rotate int
+	// Scale is a height such as "720p", or a size such as "1280x720".
+	Scale string
+}
+
+var (
+	cropRe  = regexp.MustCompile(`^(\d+):(\d+)(?::(\d+):(\d+))?$`)
+	scaleRe = regexp.MustCompile(`^(?:(\d+)x)?(\d+)p?$`)
+)
+
+func (v VideoTransform) isZero() bool {
+	return v == VideoTransform{}
+}
+
+// validate checks the transform. Sizes must be even, which the H.264 and
+// VP9 encoders need for their chroma subsampling.
+func (v VideoTransform) validate() error {
+	if v.Crop != "" {
+		m := cropRe.FindStringSubmatch(v.Crop)
+		if m == nil {
+			return fmt.Errorf("invalid crop %q: want W:H or W:H:X:Y in pixels", v.Crop)
+		}
+		if !evenSize(m[1]) || !evenSize(m[2]) {
+			return fmt.Errorf("invalid crop %q: width and height must be even and non-zero", v.Crop)
+		}
+	}
+	switch v.Rotate {
+	case 0, 90, 180, 270:
+	default:
+		return fmt.Errorf("invalid rotation %d: want 0, 90, 180 or 270", v.Rotate)
+	}
+	if v.Scale != "" {
+		m := scaleRe.FindStringSubmatch(v.Scale)
+		if m == nil {
+			return fmt.Errorf("invalid scale %q: want a height such as 720p or a size such as 1280x720", v.Scale)
+		}
+		if (m[1] != "" && !evenSize(m[1])) || !evenSize(m[2]) {
+			return fmt.Errorf("invalid scale %q: width and height must be even and non-zero", v.Scale)
+		}
+	}
+	return nil
+}
+
+func evenSize(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n%2 == 0
+}
+
+// filters are the video filters applying the transform. scale, if set,
+// takes the place of the transform's own scaling, as a preview's does.
+func (v VideoTransform) filters(scale string) []string {
+	var filters []string
+	if v.Crop != "" {
+		filters = append(filters, "crop="+v.Crop)
+	}
+	switch v.Rotate {
+	case 90:
+		filters = append(filters, "transpose=clock")
+	case 180:
+		filters = append(filters, "hflip,vflip")
+	case 270:
+		filters = append(filters, "transpose=cclock")
+	}
+	if scale == "" && v.Scale != "" {
+		m := scaleRe.FindStringSubmatch(v.Scale)
+		if m[1] == "" {
+			// Keep the aspect ratio, rounding the width to an even size.
+			scale = "scale=-2:" + m[2]
+		} else {
+			scale = fmt.Sprintf("scale=%s:%s", m[1], m[2])
+		}
+	}
+	if scale != "" {
+		filters = append(filters, scale)
+	}
+	return filters
+}
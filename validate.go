@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// shortTrackSeconds is the length below which a track is probably a
+// mistyped timestamp rather than a real track.
+const shortTrackSeconds = 30
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is one problem found in a tracklist, with a suggested fix.
+type Diagnostic struct {
+	Severity Severity
+	// Line is the tracklist line, or 0 for tracks that did not come from it.
+	Line    int
+	Message string
+	Fix     string
+}
+
+func (d Diagnostic) String() string {
+	where := "tracklist"
+	if d.Line > 0 {
+		where = fmt.Sprintf("line %d", d.Line)
+	}
+	s := fmt.Sprintf("%s: %s: %s", where, d.Severity, d.Message)
+	if d.Fix != "" {
+		s += " (" + d.Fix + ")"
+	}
+	return s
+}
+
+// validationError carries the error diagnostics that stopped a job.
+type validationError []Diagnostic
+
+func (e validationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, d := range e {
+		msgs[i] = d.String()
+	}
+	return "invalid tracklist: " + strings.Join(msgs, "; ")
+}
+
+// validateTracks checks tracks whose end times have been calculated. A
+// duration of 0 means the media length is unknown, and the checks that need
+// it are skipped.
+func validateTracks(tracks []Track, duration float64) []Diagnostic {
+	var diags []Diagnostic
+	add := func(sev Severity, t Track, fix, format string, args ...any) {
+		diags = append(diags, Diagnostic{Severity: sev, Line: t.Line, Message: fmt.Sprintf(format, args...), Fix: fix})
+	}
+
+	seen := make(map[string]Track)
+	for i, t := range tracks {
+		name := fmt.Sprintf("%q", t.MainArtist+" - "+t.MainTitle)
+		// The length is meaningless when the next track is out of order
+		// (reported on that track) or the media length is unknown.
+		knownEnd := (i == len(tracks)-1 && duration > 0) ||
+			(i < len(tracks)-1 && tracks[i+1].StartTime >= t.StartTime)
+
+		if i > 0 && t.StartTime < tracks[i-1].StartTime {
+			prev := tracks[i-1]
+			add(SeverityError, t, "check both timestamps or reorder the lines",
+				"%s starts at %s, before %s at %s on line %d",
+				name, formatTimestamp(t.StartTime), fmt.Sprintf("%q", prev.MainArtist+" - "+prev.MainTitle),
+				formatTimestamp(prev.StartTime), prev.Line)
+		} else if duration > 0 && t.StartTime >= duration {
+			add(SeverityError, t, "check for a typo in the hours or minutes, or use the full recording",
+				"%s starts at %s, after the media ends at %s",
+				name, formatTimestamp(t.StartTime), formatTimestamp(duration))
+		} else if knownEnd {
+			length := t.EndTime - t.StartTime
+			if length <= 0 {
+				add(SeverityError, t, "remove the duplicate line, give it its own timestamp or fix its end time override",
+					"%s has no length: it starts at %s and ends at %s",
+					name, formatTimestamp(t.StartTime), formatTimestamp(t.EndTime))
+			} else if length < shortTrackSeconds {
+				add(SeverityWarning, t, "check the timestamps of this and the next track",
+					"%s is only %.0f seconds long", name, length)
+			}
+		}
+
+		nextInRange := i == len(tracks)-1 || tracks[i+1].StartTime < duration
+		if duration > 0 && t.EndTime > duration && nextInRange {
+			add(SeverityError, t, "check the end time override",
+				"%s ends at %s, after the media ends at %s",
+				name, formatTimestamp(t.EndTime), formatTimestamp(duration))
+		}
+
+		if t.Estimated && t.Detected {
+			add(SeverityWarning, t, "check the transition, then add the real timestamp or set it with an override",
+				"%s has no timestamp; the transition found at %s is uncertain (confidence %.2f)",
+				name, formatTimestamp(t.StartTime), t.Confidence)
+		} else if t.Estimated {
+			add(SeverityWarning, t, "add the real timestamp, or set it with an override",
+				"%s has no timestamp; estimated as %s", name, formatTimestamp(t.StartTime))
+		}
+
+		for _, line := range t.Merged {
+			diags = append(diags, Diagnostic{Severity: SeverityWarning, Line: line,
+				Message: fmt.Sprintf("starts with %s on line %d, so it is merged into it as a w/ track", name, t.Line),
+				Fix:     "give it its own timestamp if it was played after, or pass --duplicates error"})
+		}
+
+		key := normalizeTrackKey(t.MainArtist + " " + t.MainTitle)
+		if strings.EqualFold(t.MainTitle, "ID") || key == "" {
+			continue
+		}
+		if prev, ok := seen[key]; ok {
+			add(SeverityWarning, t, "remove it if the track was not really played twice",
+				"%s repeats the track on line %d", name, prev.Line)
+			continue
+		}
+		seen[key] = t
+	}
+	return diags
+}
+
+// checkTracks splits diagnostics into an error, if any are errors, and the
+// remaining warnings.
+func checkTracks(tracks []Track, duration float64) ([]Diagnostic, error) {
+	var errs validationError
+	var warnings []Diagnostic
+	for _, d := range validateTracks(tracks, duration) {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		} else {
+			warnings = append(warnings, d)
+		}
+	}
+	if len(errs) > 0 {
+		return warnings, errs
+	}
+	return warnings, nil
+}
+
+// validateCommand checks a tracklist without splitting anything. The input
+// is optional; without it the checks against the media length are skipped.
+func validateCommand(flags *flag.FlagSet) func(logger *slog.Logger) error {
+	tracklistPath := flags.String("tracklist", "", "Tracklist to check")
+	input := flags.String("input", "", "Media file or URL, to check timestamps against its duration")
+	overrides := flags.String("overrides", "", "Overrides file applied before checking")
+	recordingStart := flags.String("recording-start", "", "Time of day the recording began, when the tracklist's timestamps are times of day")
+	measure := flags.Bool("measure-duration", false, "Read the input through to measure its length instead of trusting its header")
+	segments := flags.String("segments", SegmentsExclude, "Announcements and breaks marked in the tracklist: exclude, bonus or include, as for the split")
+	duplicates := flags.String("duplicates", DuplicatesMerge, "Tracks starting when the track before does: merge (into it, as played together) or error")
+	return func(logger *slog.Logger) error {
+		if *tracklistPath == "" {
+			return errors.New("--tracklist is required")
+		}
+		if !validSegmentsMode(*segments) {
+			return fmt.Errorf("invalid segments mode %q: want exclude, bonus or include", *segments)
+		}
+		if !validDuplicatesMode(*duplicates) {
+			return fmt.Errorf("invalid duplicates mode %q: want merge or error", *duplicates)
+		}
+		f, err := os.Open(*tracklistPath)
+		if err != nil {
+			return err
+		}
+		tracks, _, err := parseTracklist(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("parse tracklist: %v", err)
+		}
+		if len(tracks) == 0 {
+			return errors.New("tracklist contains no tracks")
+		}
+		if *recordingStart != "" {
+			if err := clockStarts(tracks, *recordingStart); err != nil {
+				return err
+			}
+		}
+		var duration, reported float64
+		if *input != "" {
+			if duration, reported, err = mediaDuration(*input, *measure); err != nil {
+				return err
+			}
+		}
+		if err := interpolateStarts(tracks, duration); err != nil {
+			return err
+		}
+		tracks = applySegments(tracks, *segments)
+		if *overrides != "" {
+			o, err := loadOverrides(*overrides)
+			if err != nil {
+				return err
+			}
+			if err := applyOverrides(tracks, numberedTracks(tracks, *segments, *duplicates), o); err != nil {
+				return err
+			}
+		}
+		tracks = calculateEndTimes(tracks, duration, *duplicates)
+
+		diags := validateTracks(tracks, duration)
+		if reported != 0 {
+			diags = append(diags, durationWarning(tracks, reported, duration))
+		}
+		writeDiagnostics(os.Stdout, *tracklistPath, diags)
+		logger.Info("Validated tracklist", "tracks", len(tracks), "problems", len(diags))
+		for _, d := range diags {
+			if d.Severity == SeverityError {
+				return errors.New("tracklist has errors")
+			}
+		}
+		return nil
+	}
+}
+
+// writeDiagnostics prints diagnostics in the compiler-style "file:line:"
+// form editors can jump to.
+func writeDiagnostics(w io.Writer, path string, diags []Diagnostic) {
+	for _, d := range diags {
+		fmt.Fprintf(w, "%s:%d: %s: %s\n", path, d.Line, d.Severity, d.Message)
+		if d.Fix != "" {
+			fmt.Fprintf(w, "\tfix: %s\n", d.Fix)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// silenceDB is the peak level at or below which an output counts as
+	// silent.
+	silenceDB = -60.0
+	// durationTolerance is how far, as a fraction of the planned length,
+	// an output may be off before it is flagged; at least one second is
+	// always allowed for frame and packet boundaries.
+	durationTolerance = 0.02
+)
+
+var maxVolumeRe = regexp.MustCompile(`max_volume:\s*(-?[\d.]+|-inf) dB`)
+
+type probeResult struct {
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+	} `json:"streams"`
+}
+
+// verifyOutputs checks every finished track's output against the plan,
+// recording problems for the report, and returns how many tracks were
+// flagged.
+func verifyOutputs(ctx context.Context, job *Job, logger *slog.Logger) int {
+	states := job.States()
+	problems := make([][]string, len(job.Tracks))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxWorkers)
+	for i := range job.Tracks {
+		if states[i].Status != StatusDone {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			problems[i] = verifyOutput(ctx, &job.Tracks[i], job)
+		}(i)
+	}
+	wg.Wait()
+
+	flagged := 0
+	for i, p := range problems {
+		if len(p) == 0 {
+			continue
+		}
+		flagged++
+		for _, msg := range p {
+			logger.Warn("Output failed verification", "track", job.Tracks[i].MainTitle, "problem", msg)
+		}
+	}
+
+	job.mu.Lock()
+	job.problems = problems
+	job.mu.Unlock()
+	return flagged
+}
+
+// Problems returns what --verify found wrong with track i's output.
+func (j *Job) Problems(i int) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if i < len(j.problems) {
+		return j.problems[i]
+	}
+	return nil
+}
+
+// verifyOutput probes one output and returns what is wrong with it.
+func verifyOutput(ctx context.Context, t *Track, job *Job) []string {
+	out, err := executor.Probe(ctx, "-v", "error",
+		"-show_entries", "format=duration:stream=codec_type,codec_name",
+		"-of", "json", t.OutputFilename)
+	if err != nil {
+		return []string{fmt.Sprintf("unreadable: ffprobe error: %v", err)}
+	}
+	var probe probeResult
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return []string{fmt.Sprintf("unreadable: %v", err)}
+	}
+
+	var problems []string
+	_, want := job.clipRange(t)
+	got, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	switch {
+	case err != nil:
+		problems = append(problems, "duration unknown")
+	case math.Abs(got-want) > math.Max(1, want*durationTolerance):
+		what := "too long"
+		if got < want {
+			what = "truncated"
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s long, planned %s",
+			what, formatTimestamp(got), formatTimestamp(want)))
+	}
+
+	codecs := make(map[string]string)
+	for _, s := range probe.Streams {
+		if _, ok := codecs[s.CodecType]; !ok {
+			codecs[s.CodecType] = s.CodecName
+		}
+	}
+	// ffprobe names the audio codecs after the formats.
+	expect := map[string]string{"audio": job.audioFormat()}
+	if job.Video {
+		expect = map[string]string{"video": "h264", "audio": "aac"}
+		if job.surround() {
+			expect["audio"] = "eac3"
+		}
+	}
+	if job.webm() {
+		expect = map[string]string{"video": "vp9", "audio": "opus"}
+		if job.VideoCodec == CodecAV1 {
+			expect["video"] = "av1"
+		}
+	}
+	if job.VideoCopy {
+		// The video stream is copied, so any codec is expected.
+		expect["video"] = ""
+	}
+	for kind, codec := range expect {
+		switch got, ok := codecs[kind]; {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("no %s stream", kind))
+		case codec != "" && got != codec:
+			problems = append(problems, fmt.Sprintf("%s stream is %s, expected %s", kind, got, codec))
+		}
+	}
+
+	if _, ok := codecs["audio"]; ok {
+		problems = append(problems, decodeCheck(ctx, t.OutputFilename)...)
+	}
+	return problems
+}
+
+// decodeCheck decodes the whole audio stream, reporting decoder errors and
+// outputs that are silent throughout.
+func decodeCheck(ctx context.Context, path string) []string {
+	var stderr bytes.Buffer
+	// "level+" prefixes every log line with its level, so decoder errors
+	// can be told apart from the stream metadata printed at info level.
+	err := runFFmpeg(ctx, &Command{Args: []string{"-hide_banner", "-nostats", "-loglevel", "level+info",
+		"-i", path, "-map", "0:a:0", "-af", "volumedetect", "-f", "null", "-"}, Stderr: &stderr})
+	if err != nil {
+		return []string{fmt.Sprintf("decode failed: %v", err)}
+	}
+
+	var problems []string
+	decodeErrors := 0
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if strings.Contains(line, "[error]") || strings.Contains(line, "[fatal]") {
+			decodeErrors++
+		}
+	}
+	if decodeErrors > 0 {
+		problems = append(problems, fmt.Sprintf("%d decode errors", decodeErrors))
+	}
+
+	if m := maxVolumeRe.FindStringSubmatch(stderr.String()); m != nil {
+		peak, err := strconv.ParseFloat(m[1], 64)
+		if m[1] == "-inf" || (err == nil && peak <= silenceDB) {
+			problems = append(problems, fmt.Sprintf("silent: peak %s dB", m[1]))
+		}
+	}
+	return problems
+}
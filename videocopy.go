@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// probeKeyframes lists the times of the keyframes of the input's video
+// stream with the given index, in order. It reads packet flags rather than
+// decoding, so it takes about as long as reading the file once.
+func probeKeyframes(input string, video int) ([]float64, error) {
+	args := []string{"-v", "error", "-select_streams", fmt.Sprintf("v:%d", video),
+		"-show_entries", "packet=pts_time,flags", "-of", "csv=p=0"}
+	output, err := executor.Probe(context.Background(), append(args, inputArgs(input)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	var keyframes []float64
+	sc := bufio.NewScanner(bytes.NewReader(output))
+	for sc.Scan() {
+		pts, flags, ok := strings.Cut(sc.Text(), ",")
+		if !ok || !strings.Contains(flags, "K") {
+			continue
+		}
+		if t, err := strconv.ParseFloat(pts, 64); err == nil {
+			keyframes = append(keyframes, t)
+		}
+	}
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("no video keyframes found in %s", input)
+	}
+	// Packets come in decode order, which with B-frames is not pts order.
+	sort.Float64s(keyframes)
+	return keyframes, nil
+}
+
+// snapToKeyframes moves every start time to the nearest keyframe, since a
+// stream copy can only begin on one, and records how far each moved.
+func snapToKeyframes(tracks []Track, keyframes []float64) {
+	for i := range tracks {
+		t := &tracks[i]
+		k := sort.SearchFloat64s(keyframes, t.StartTime)
+		best := keyframes[min(k, len(keyframes)-1)]
+		if k > 0 && (k == len(keyframes) || t.StartTime-keyframes[k-1] < keyframes[k]-t.StartTime) {
+			best = keyframes[k-1]
+		}
+		t.Drift = best - t.StartTime
+		t.StartTime = best
+	}
+}
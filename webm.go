@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	CodecVP9 = "vp9"
+	CodecAV1 = "av1"
+)
+
+func (j *Job) webm() bool {
+	return j.Ext == ".webm"
+}
+
+// validateWebM checks the options that only apply to, or conflict with,
+// WebM output.
+func validateWebM(opts Options) error {
+	if _, video := opts.formats(); video != FormatWebM {
+		if opts.VideoCodec != "" {
+			return errors.New("--video-codec requires --format webm")
+		}
+		return nil
+	}
+	if opts.VideoCopy {
+		return errors.New("--video-copy cannot be combined with --format webm")
+	}
+	if opts.VideoCodec != "" && opts.VideoCodec != CodecVP9 && opts.VideoCodec != CodecAV1 {
+		return fmt.Errorf("invalid video codec %q: want vp9 or av1", opts.VideoCodec)
+	}
+	return nil
+}
+
+// webmArgs encode VP9 or AV1 video with Opus audio. Both video encoders run
+// in constant-quality mode; preview trades quality for speed.
+func webmArgs(job *Job, preview bool) []string {
+	var args []string
+	switch {
+	case job.VideoCodec == CodecAV1 && preview:
+		args = []string{"-c:v", "libsvtav1", "-preset", "12", "-crf", "50"}
+	case job.VideoCodec == CodecAV1:
+		args = []string{"-c:v", "libsvtav1", "-preset", "8", "-crf", "35"}
+	case preview:
+		args = []string{"-c:v", "libvpx-vp9", "-deadline", "realtime", "-cpu-used", "8",
+			"-crf", "45", "-b:v", "0"}
+	default:
+		args = []string{"-c:v", "libvpx-vp9", "-deadline", "good", "-cpu-used", "4",
+			"-row-mt", "1", "-crf", "32", "-b:v", "0"}
+	}
+
+	return append(args, "-c:a", "libopus", "-b:a", opusBitrate(job, preview), "-y")
+}